@@ -36,3 +36,7 @@ type termios struct {
 	Ispeed int32
 	Ospeed int32
 }
+
+func selectRead(n int, set *syscall.FdSet, timeout *syscall.Timeval) error {
+	return syscall.Select(n, set, nil, nil, timeout)
+}
@@ -6,67 +6,256 @@ addition to the xterm codes supported by everything else.
 package liner
 
 import (
-	"bufio"
 	"container/ring"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"regexp"
 	"strings"
 	"sync"
 	"unicode/utf8"
 )
 
 type commonState struct {
-	terminalSupported bool
-	terminalOutput    bool
-	history           []string
-	historyMutex      sync.RWMutex
-	completer         WordCompleter
-	columns           int
-	killRing          *ring.Ring
+	terminalSupported  bool
+	terminalOutput     bool
+	w                  io.Writer
+	historyStore       HistoryStore
+	historyOnce        sync.Once
+	historyMutex       sync.RWMutex
+	historyLimit       int
+	historyEraseDups   bool
+	historyIgnoreSpace bool
+	historyIgnore      []*regexp.Regexp
+	historyMinLength   int
+	completer          RichCompleter
+	columns            int
+	rows               int
+	killRing           *ring.Ring
+	multiLineMode      bool
+	continuation       string // prompt of the rows after the first
+	rightPrompt        string
+	toolbar            []string
+	toolbarMutex       sync.Mutex
+	inputComplete      func(string) bool // nil unless input can span lines
+	cursorRow          int               // cursor row, relative to the first row of the prompt
+	cursorCells        int               // cells between the start of the prompt and the cursor
+	renderColumns      int               // terminal width at the last refresh
+	nextRow            int               // first row below the buffer, relative to the prompt
+	cursorCol          int               // cursor column
+	below              []string          // rows drawn under the buffer
+	belowRows          int               // rows drawn under the buffer by the last refresh
+	ctrlCMode          CtrlCMode
+	tabStyle           TabStyle
+	pasteMode          PasteMode
+	suggester          Suggester
+	highlighter        Highlighter
+	validator          Validator
+	message            string          // shown below the line until it is changed
+	suggestion         string          // shown after the buffer by the next refresh
+	queryItems         int             // 0 for the default, -1 to never ask
+	ctx                context.Context // of the prompt in progress
+	outputMutex        sync.Mutex      // held while a prompt draws, or Printf prints
+	prompting          bool
+	shownPrompt        string // the prompt drawn by the last refresh
+	shownBuf           string // the line drawn by the last refresh
+	shownPos           int
+	shownSuggestion    string
+}
+
+// Config describes the streams used by a State created with
+// NewLinerWithConfig.
+type Config struct {
+	// Input is read for keystrokes. Defaults to os.Stdin.
+	Input io.Reader
+	// Output receives the prompt and everything else Liner draws.
+	// Defaults to os.Stdout.
+	Output io.Writer
+	// Terminal is the terminal device behind Input and Output, if any. It
+	// is switched into raw mode, and queried for its size unless TermSize
+	// is set.
+	Terminal *os.File
+	// TermSize reports the current size of the terminal in character
+	// cells. It is required for line editing when Terminal is nil;
+	// without either, lines are read without editing.
+	TermSize func() (columns, rows int)
 }
 
 var errNotTerminalOutput = errors.New("standard output is not a terminal")
 
+// ErrPromptAborted is returned from Prompt or PasswordPrompt when the user
+// presses Ctrl-C, if the Ctrl-C mode is CtrlCAbort. The line typed so far is
+// returned along with it.
+var ErrPromptAborted = errors.New("prompt aborted")
+
+// CtrlCMode selects what Ctrl-C does during Prompt and PasswordPrompt.
+type CtrlCMode int
+
+// Ctrl-C modes
+const (
+	// CtrlCBeep beeps, leaving the line unchanged. This is the default.
+	CtrlCBeep CtrlCMode = iota
+	// CtrlCAbort makes the prompt return ErrPromptAborted.
+	CtrlCAbort
+	// CtrlCClear discards the line and shows the prompt again on a new row.
+	CtrlCClear
+)
+
+// ctxDone returns a channel that is closed when the context of the prompt in
+// progress is done, or nil if there is no such context.
+func (s *State) ctxDone() <-chan struct{} {
+	if s.ctx == nil {
+		return nil
+	}
+	return s.ctx.Done()
+}
+
 // Max elements to save on the killring
 const KillRingMax = 60
 
-// HistoryLimit is the maximum number of entries saved in the scrollback history.
+// HistoryLimit is the maximum number of entries saved in the scrollback
+// history, unless SetHistoryLimit is called.
 const HistoryLimit = 1000
 
+// SetHistoryStore sets the store of the scrollback history, in place of the
+// MemoryHistory used by default.
+func (s *State) SetHistoryStore(h HistoryStore) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	s.historyOnce.Do(func() {})
+	s.historyStore = h
+	if l, ok := h.(limitSetter); ok && s.historyLimit > 0 {
+		l.SetLimit(s.historyLimit)
+	}
+}
+
+// history returns the store of the scrollback history.
+func (s *commonState) history() HistoryStore {
+	s.historyOnce.Do(func() {
+		s.historyStore = &MemoryHistory{}
+	})
+	return s.historyStore
+}
+
+// limitSetter is implemented by the HistoryStores that drop their oldest
+// entries beyond a limit.
+type limitSetter interface {
+	SetLimit(n int)
+}
+
+// dupEraser is implemented by the HistoryStores that can remove the entries
+// equal to an entry and append it as a single change.
+type dupEraser interface {
+	appendErasingDups(entry string) error
+}
+
+// appendErasingDups removes the entries of h equal to entry, and then appends
+// it.
+func appendErasingDups(h HistoryStore, entry string) error {
+	if d, ok := h.(dupEraser); ok {
+		return d.appendErasingDups(entry)
+	}
+	if err := h.Delete(entry); err != nil {
+		return err
+	}
+	return h.Append(entry)
+}
+
+// SetHistoryLimit sets the maximum number of entries in the scrollback
+// history, in place of HistoryLimit. It is passed on to the history store if
+// it has a SetLimit method, as MemoryHistory and FileHistory do, and limits
+// the entries written by WriteHistory.
+func (s *State) SetHistoryLimit(n int) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	s.historyLimit = n
+	if l, ok := s.history().(limitSetter); ok {
+		l.SetLimit(n)
+	}
+}
+
+// SetHistoryEraseDups sets whether adding an entry to the scrollback history
+// removes the earlier entries equal to it, wherever they are. Otherwise, only
+// an entry equal to the one just before it is dropped.
+func (s *State) SetHistoryEraseDups(eraseDups bool) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	s.historyEraseDups = eraseDups
+}
+
+// SetHistoryIgnoreSpace sets whether lines that start with a space are left
+// out of the scrollback history, so that a command can be kept out of it by
+// typing a space before it.
+func (s *State) SetHistoryIgnoreSpace(ignoreSpace bool) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	s.historyIgnoreSpace = ignoreSpace
+}
+
+// SetHistoryIgnorePatterns sets patterns for the lines that are left out of
+// the scrollback history, such as lines with passwords or tokens typed by
+// mistake. A line is left out if any of the patterns matches it.
+func (s *State) SetHistoryIgnorePatterns(patterns ...*regexp.Regexp) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	s.historyIgnore = append([]*regexp.Regexp(nil), patterns...)
+}
+
+// SetHistoryMinLength sets the minimum number of characters of the lines
+// kept in the scrollback history. Shorter lines are left out.
+func (s *State) SetHistoryMinLength(n int) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	s.historyMinLength = n
+}
+
+// keepInHistory reports whether the policies set for the scrollback history
+// let item be kept in it.
+func (s *commonState) keepInHistory(item string) bool {
+	if s.historyIgnoreSpace && strings.HasPrefix(item, " ") {
+		return false
+	}
+	if utf8.RuneCountInString(item) < s.historyMinLength {
+		return false
+	}
+	for _, re := range s.historyIgnore {
+		if re.MatchString(item) {
+			return false
+		}
+	}
+	return true
+}
+
 // ReadHistory reads scrollback history from r. Returns the number of lines
-// read, and any read error (except io.EOF).
+// read, and any read error (except io.EOF). The lines that the policies set
+// for the history leave out are skipped. Each line is an entry, with its
+// newlines and backslashes written as \n and \\, as WriteHistory writes them.
 func (s *State) ReadHistory(r io.Reader) (num int, err error) {
 	s.historyMutex.Lock()
 	defer s.historyMutex.Unlock()
 
-	in := bufio.NewReader(r)
-	num = 0
-	for {
-		line, part, err := in.ReadLine()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return num, err
-		}
-		if part {
-			return num, fmt.Errorf("line %d is too long", num+1)
-		}
-		if !utf8.Valid(line) {
-			return num, fmt.Errorf("invalid string at line %d", num+1)
-		}
+	h := s.history()
+	err = readEntries(r, func(line string) error {
 		num++
-		s.history = append(s.history, string(line))
-		if len(s.history) > HistoryLimit {
-			s.history = s.history[1:]
+		entry := entryUnescaper.Replace(line)
+		if !s.keepInHistory(entry) {
+			return nil
 		}
-	}
-	return num, nil
+		if s.historyEraseDups {
+			return appendErasingDups(h, entry)
+		}
+		return h.Append(entry)
+	})
+	return num, err
 }
 
 // WriteHistory writes scrollback history to w. Returns the number of lines
-// successfully written, and any write error.
+// successfully written, and any write error. The entries that the policies
+// set for the history leave out are not written. Each entry is written on a
+// line, with its newlines and backslashes written as \n and \\, so that
+// entries typed on several rows are read back whole.
 //
 // Unlike the rest of liner's API, WriteHistory is safe to call
 // from another goroutine while Prompt is in progress.
@@ -76,8 +265,24 @@ func (s *State) WriteHistory(w io.Writer) (num int, err error) {
 	s.historyMutex.RLock()
 	defer s.historyMutex.RUnlock()
 
-	for _, item := range s.history {
-		_, err := fmt.Fprintln(w, item)
+	// Pick the entries from the most recent, so that the last of
+	// duplicates is kept
+	limit := s.historyLimit
+	if limit <= 0 {
+		limit = HistoryLimit
+	}
+	var items []string
+	seen := make(map[string]bool)
+	s.history().Iterate(func(item string) bool {
+		if s.keepInHistory(item) && !(s.historyEraseDups && seen[item]) {
+			items = append(items, item)
+			seen[item] = true
+		}
+		return len(items) < limit
+	})
+
+	for i := len(items) - 1; i >= 0; i-- {
+		_, err := fmt.Fprintln(w, entryEscaper.Replace(items[i]))
 		if err != nil {
 			return num, err
 		}
@@ -87,25 +292,33 @@ func (s *State) WriteHistory(w io.Writer) (num int, err error) {
 }
 
 // AppendHistory appends an entry to the scrollback history. AppendHistory
-// should be called iff Prompt returns a valid command.
+// should be called iff Prompt returns a valid command. The entry is left out
+// if the policies set for the history say so.
 func (s *State) AppendHistory(item string) {
 	s.historyMutex.Lock()
 	defer s.historyMutex.Unlock()
 
-	if len(s.history) > 0 {
-		if item == s.history[len(s.history)-1] {
-			return
-		}
+	if !s.keepInHistory(item) {
+		return
+	}
+	h := s.history()
+	if s.historyEraseDups {
+		appendErasingDups(h, item)
+		return
 	}
-	s.history = append(s.history, item)
-	if len(s.history) > HistoryLimit {
-		s.history = s.history[1:]
+	duplicate := false
+	h.Iterate(func(entry string) bool {
+		duplicate = entry == item
+		return false
+	})
+	if !duplicate {
+		h.Append(item)
 	}
 }
 
 // Returns the history lines starting with prefix
 func (s *State) getHistoryByPrefix(prefix string) (ph []string) {
-	for _, h := range s.history {
+	for _, h := range historyEntries(s.history()) {
 		if strings.HasPrefix(h, prefix) {
 			ph = append(ph, h)
 		}
@@ -113,16 +326,29 @@ func (s *State) getHistoryByPrefix(prefix string) (ph []string) {
 	return
 }
 
+// Returns the most recent history line that is longer than prefix and starts
+// with it
+func (s *State) historySuggestion(prefix string) string {
+	found := ""
+	s.history().Iterate(func(h string) bool {
+		if len(h) > len(prefix) && strings.HasPrefix(h, prefix) {
+			found = h
+			return false
+		}
+		return true
+	})
+	return found
+}
+
 // Returns the history lines matching the inteligent search
 func (s *State) getHistoryByPattern(pattern string) (ph []string, pos []int) {
 	if pattern == "" {
 		return
 	}
-	for _, h := range s.history {
-		if i := strings.Index(h, pattern); i >= 0 {
-			ph = append(ph, h)
-			pos = append(pos, i)
-		}
+	found, _ := s.history().Search(pattern)
+	for i := len(found) - 1; i >= 0; i-- {
+		ph = append(ph, found[i])
+		pos = append(pos, strings.Index(found[i], pattern))
 	}
 	return
 }
@@ -139,6 +365,26 @@ type Completer func(line string) []string
 // to the completer which may returns ("Hello, ", {"world", "Word"}, "!!!") to have "Hello, world!!!".
 type WordCompleter func(line string, pos int) (head string, completions []string, tail string)
 
+// Completion is a completion candidate returned by a RichCompleter.
+type Completion struct {
+	// Text replaces the partial word being completed.
+	Text string
+	// Display is shown instead of Text when candidates are listed. If it
+	// is empty, Text is shown.
+	Display string
+	// Description is shown next to the candidate when candidates are
+	// listed, aligned with the descriptions of the others.
+	Description string
+	// Suffix is inserted after Text when the candidate is chosen, unless
+	// the line already continues with it; a space, or "/" after the name
+	// of a directory, for example.
+	Suffix string
+}
+
+// RichCompleter is like WordCompleter, but its completion candidates can
+// carry display text, descriptions and suffixes.
+type RichCompleter func(line string, pos int) (head string, completions []Completion, tail string)
+
 // SetCompleter sets the completion function that Liner will call to
 // fetch completion candidates when the user presses tab.
 func (s *State) SetCompleter(f Completer) {
@@ -146,17 +392,282 @@ func (s *State) SetCompleter(f Completer) {
 		s.completer = nil
 		return
 	}
-	s.completer = func(line string, pos int) (string, []string, string) {
-		return "", f(line[:pos]), line[pos:]
+	s.completer = func(line string, pos int) (string, []Completion, string) {
+		return "", completions(f(line[:pos])), line[pos:]
 	}
 }
 
 // SetWordCompleter sets the completion function that Liner will call to
 // fetch completion candidates when the user presses tab.
 func (s *State) SetWordCompleter(f WordCompleter) {
+	if f == nil {
+		s.completer = nil
+		return
+	}
+	s.completer = func(line string, pos int) (string, []Completion, string) {
+		head, list, tail := f(line, pos)
+		return head, completions(list), tail
+	}
+}
+
+// SetRichCompleter sets the completion function that Liner will call to
+// fetch completion candidates when the user presses tab.
+func (s *State) SetRichCompleter(f RichCompleter) {
 	s.completer = f
 }
 
+// completions converts candidates returned by a Completer or WordCompleter.
+func completions(list []string) []Completion {
+	if list == nil {
+		return nil
+	}
+	c := make([]Completion, len(list))
+	for i, text := range list {
+		c[i].Text = text
+	}
+	return c
+}
+
+// display returns what is shown for c when candidates are listed.
+func (c Completion) display() string {
+	if c.Display != "" {
+		return c.Display
+	}
+	return c.Text
+}
+
+// apply returns the line with c completing the word between head and tail,
+// and the position of the cursor after c.
+func (c Completion) apply(head, tail string) ([]rune, int) {
+	line := head + c.Text
+	if !strings.HasPrefix(tail, c.Suffix) {
+		line += c.Suffix
+	}
+	return []rune(line + tail), utf8.RuneCountInString(head + c.Text + c.Suffix)
+}
+
+// Suggester takes the currently edited line and returns a line that starts
+// with it, to be suggested as it is typed, or "" for no suggestion.
+type Suggester func(line string) string
+
+// SetSuggester sets the function that Liner will call to suggest how to
+// complete the line as it is typed. The rest of the suggested line is shown
+// dimmed after it: Right or End accepts all of it, and Alt-F accepts its next
+// word. A nil f turns suggestions off, which is the default.
+func (s *State) SetSuggester(f Suggester) {
+	s.suggester = f
+}
+
+// SuggestHistory makes Liner suggest the most recent history entry that
+// starts with the line being typed, like the fish shell.
+func (s *State) SuggestHistory() {
+	s.suggester = s.historySuggestion
+}
+
+// Highlighter takes the currently edited line with the cursor position and
+// returns the line to display, styled with ANSI color sequences (such as
+// "\x1b[1;34m"). Without the color sequences, it must be the line itself.
+type Highlighter func(line string, pos int) string
+
+// SetHighlighter sets the function that Liner will call to style the line
+// each time it is displayed. A nil f displays the line as it is.
+func (s *State) SetHighlighter(f Highlighter) {
+	s.highlighter = f
+}
+
+// Validator is called with the line when Enter is pressed. If it returns an
+// error, the line is not accepted, and the error message is displayed below it
+// until it is changed. A *ValidationError also moves the cursor.
+type Validator func(line string) error
+
+// ValidationError is an error returned by a Validator to move the cursor to
+// Pos, in runes from the start of the line, where the problem is.
+type ValidationError struct {
+	Message string
+	Pos     int
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// SetValidator sets the function that Liner will call to check the line
+// before accepting it. A nil v accepts any line.
+func (s *State) SetValidator(v Validator) {
+	s.validator = v
+}
+
+// TabStyle is used to select how tab completions are displayed.
+type TabStyle int
+
+// Three tab styles are currently available:
+//
+// TabCircular cycles through each completion item and displays it directly on
+// the prompt
+//
+// TabPrints inserts the longest prefix shared by the completion items, and
+// prints the list of them below the prompt after a second tab key is pressed.
+// This behaves similar to GNU readline and BASH (which uses readline)
+//
+// TabMenu opens a menu of the completion items below the prompt, in which the
+// arrow keys, Tab and Shift-Tab select an item, typing narrows the items down,
+// Enter inserts the selected item and Esc closes the menu
+const (
+	TabCircular TabStyle = iota
+	TabPrints
+	TabMenu
+)
+
+// SetTabCompletionStyle sets the behavior when the Tab key is pressed for
+// auto-completion. TabCircular is the default behavior and cycles through the
+// list of candidates at the prompt. TabPrints will print the available
+// completion candidates to the screen similar to BASH and GNU Readline.
+func (s *State) SetTabCompletionStyle(tabStyle TabStyle) {
+	s.tabStyle = tabStyle
+}
+
+// DefaultCompletionQueryItems is the number of completion candidates from
+// which TabPrints asks before listing them, unless SetCompletionQueryItems
+// was called.
+const DefaultCompletionQueryItems = 100
+
+// SetCompletionQueryItems sets the number of completion candidates from which
+// TabPrints asks "Display all N possibilities? (y or n)" before listing them.
+// If n is 0 or less, it never asks.
+func (s *State) SetCompletionQueryItems(n int) {
+	if n <= 0 {
+		n = -1
+	}
+	s.queryItems = n
+}
+
+// PasteMode is used to select what happens to the newlines in text pasted
+// into the terminal.
+type PasteMode int
+
+// Liner turns on the bracketed paste mode of terminals that support it while
+// prompting, and inserts text pasted into the terminal without interpreting
+// it as keystrokes. The newlines in it are then handled as follows:
+//
+// PasteSpaces replaces each newline with a space.
+//
+// PasteNewlines inserts the newlines into the line as they are.
+//
+// PasteReject beeps and discards the pasted text if it has any newlines.
+const (
+	PasteSpaces PasteMode = iota
+	PasteNewlines
+	PasteReject
+)
+
+// SetPasteMode sets how newlines in pasted text are handled. PasteSpaces is
+// the default.
+func (s *State) SetPasteMode(mode PasteMode) {
+	s.pasteMode = mode
+}
+
+// pasteText returns text as it is inserted by a paste, with its line endings
+// handled as set by SetPasteMode, or false if the paste is rejected.
+func (s *commonState) pasteText(text string) (string, bool) {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+	switch s.pasteMode {
+	case PasteNewlines:
+		return text, true
+	case PasteReject:
+		return text, !strings.Contains(text, "\n")
+	}
+	return strings.Replace(text, "\n", " ", -1), true
+}
+
+// SetMultiLineMode sets whether lines longer than the terminal width wrap
+// onto as many rows as needed, instead of scrolling horizontally within a
+// single row.
+func (s *State) SetMultiLineMode(mlmode bool) {
+	s.multiLineMode = mlmode
+}
+
+// Printf formats according to a format specifier and prints the result on
+// rows of its own above the prompt in progress, if any, which is then drawn
+// again below it as it was. It returns the number of bytes formatted and any
+// write error.
+//
+// Unlike the rest of liner's API, Printf is safe to call from another
+// goroutine while Prompt is in progress, as well as from the KeyHandler,
+// Validator, completer or Suggester that the prompt is running.
+func (s *State) Printf(format string, a ...interface{}) (int, error) {
+	text := fmt.Sprintf(format, a...)
+	return len(text), s.printAbove(text)
+}
+
+// Writer returns an io.Writer that prints what is written to it as Printf
+// does, with each Write on rows of its own. It is safe to use wherever Printf
+// is, for example as the output of a log.Logger.
+func (s *State) Writer() io.Writer {
+	return aboveWriter{s}
+}
+
+type aboveWriter struct {
+	s *State
+}
+
+func (w aboveWriter) Write(p []byte) (int, error) {
+	if err := w.s.printAbove(string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// SetRightPrompt sets a prompt that is displayed at the right edge of the
+// first row of the prompt, like the RPROMPT of zsh. It may contain ANSI color
+// sequences. It is hidden while the line being edited reaches it. An empty p
+// turns it off.
+func (s *State) SetRightPrompt(p string) {
+	s.rightPrompt = p
+}
+
+// SetToolbar sets rows that are displayed below the line being edited, such
+// as key hints or the current mode, until the line is accepted. Rows wider
+// than the terminal are cut. Calling SetToolbar without rows removes the
+// toolbar.
+//
+// SetToolbar is safe to call from another goroutine while Prompt is in
+// progress. The rows are displayed when the line is next drawn, which
+// RefreshPrompt does at once.
+func (s *State) SetToolbar(rows ...string) {
+	s.toolbarMutex.Lock()
+	defer s.toolbarMutex.Unlock()
+	s.toolbar = append([]string(nil), rows...)
+}
+
+func (s *commonState) toolbarRows() []string {
+	s.toolbarMutex.Lock()
+	defer s.toolbarMutex.Unlock()
+	return s.toolbar
+}
+
+// SetMultiLineInput lets Prompt read input that spans several lines, such as
+// a statement with an unclosed parenthesis. When Enter is pressed, complete
+// is called with the input so far, and unless it returns true, a newline is
+// inserted and editing continues on a new row that starts with the
+// continuation prompt. Up and Down move between the rows of the input before
+// moving through history. Input is displayed as in multi-line mode. A nil
+// complete turns multi-line input off.
+func (s *State) SetMultiLineInput(continuation string, complete func(input string) bool) {
+	s.continuation = continuation
+	s.inputComplete = complete
+}
+
+// multiRow reports whether the input is displayed on as many rows as needed.
+func (s *commonState) multiRow() bool {
+	return s.multiLineMode || s.inputComplete != nil
+}
+
+// SetCtrlCMode sets what Ctrl-C does during Prompt and PasswordPrompt.
+func (s *State) SetCtrlCMode(mode CtrlCMode) {
+	s.ctrlCMode = mode
+}
+
 // ModeApplier is the interface that wraps a representation of the terminal
 // mode. ApplyMode sets the terminal to this mode.
 type ModeApplier interface {
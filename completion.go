@@ -0,0 +1,359 @@
+// +build windows linux darwin openbsd freebsd netbsd
+
+package liner
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// longestCommonPrefix returns the longest prefix shared by all of list.
+func longestCommonPrefix(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	prefix := []rune(list[0])
+	for _, item := range list[1:] {
+		i := 0
+		for _, r := range item {
+			if i == len(prefix) || prefix[i] != r {
+				break
+			}
+			i++
+		}
+		prefix = prefix[:i]
+	}
+	return string(prefix)
+}
+
+// completeList is the complete command of TabPrints: it inserts the longest
+// common prefix of the candidates, and lists them if Tab is pressed again.
+func (e *Editor) completeList() error {
+	s := e.s
+	if s.completer == nil {
+		e.Beep()
+		return nil
+	}
+	head, list, tail := s.complete(string(e.line), e.pos)
+	if len(list) == 0 {
+		e.Beep()
+		return nil
+	}
+	if len(list) == 1 {
+		e.line, e.pos = list[0].apply(head, tail)
+		e.refresh()
+		return nil
+	}
+	texts := make([]string, len(list))
+	for i, c := range list {
+		texts[i] = c.Text
+	}
+	prefix := longestCommonPrefix(texts)
+	e.line = []rune(head + prefix + tail)
+	e.pos = utf8.RuneCountInString(head + prefix)
+	e.refresh()
+
+	e.Beep()
+	next, err := e.readKey()
+	if err != nil {
+		return err
+	}
+	if key, ok := next.(rune); !ok || key != tab {
+		e.unreadKey(next)
+		return nil
+	}
+	return e.printCompletions(list)
+}
+
+// printCompletions prints list below the prompt, asking first if it is long
+// and pausing after each screenful, and then redraws the prompt.
+func (e *Editor) printCompletions(list []Completion) error {
+	s := e.s
+	s.moveBelow()
+	defer func() {
+		s.printPrompt(e.p)
+		e.refresh()
+	}()
+
+	threshold := s.queryItems
+	if threshold == 0 {
+		threshold = DefaultCompletionQueryItems
+	}
+	if threshold > 0 && len(list) >= threshold {
+		fmt.Fprintf(s.w, "Display all %d possibilities? (y or n)", len(list))
+		for answered := false; !answered; {
+			next, err := e.readKey()
+			if err != nil {
+				return err
+			}
+			switch next {
+			case 'y', 'Y', ' ':
+				answered = true
+			case 'n', 'N', rune(bs), rune(esc), rune(ctrlC), rune(ctrlG):
+				fmt.Fprintln(s.w)
+				return nil
+			default:
+				e.Beep()
+			}
+		}
+		fmt.Fprintln(s.w)
+	}
+
+	rows := listRows(list, s.columns)
+	page := s.rows - 1
+	shown := 0
+	for _, row := range rows {
+		if page > 0 && shown == page {
+			fmt.Fprint(s.w, "--More--")
+			next, err := e.readKey()
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(s.w, "\r")
+			s.eraseLine()
+			switch next {
+			case rune(cr), rune(lf):
+				shown--
+			case 'q', 'Q', 'n', 'N', rune(esc), rune(ctrlC), rune(ctrlG):
+				return nil
+			default:
+				shown = 0
+			}
+		}
+		fmt.Fprintln(s.w, row)
+		shown++
+	}
+	return nil
+}
+
+// hasDescriptions reports whether any of list has a description.
+func hasDescriptions(list []Completion) bool {
+	for _, c := range list {
+		if c.Description != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// displayWidth returns the width of the widest text displayed for list.
+func displayWidth(list []Completion) int {
+	width := 0
+	for _, c := range list {
+		if w := stringWidth(c.display()); w > width {
+			width = w
+		}
+	}
+	return width
+}
+
+// listRows lays list out for printing in a terminal that is columns wide:
+// in columns sorted downwards, or, if there are descriptions, one candidate
+// per row with the descriptions aligned in a second column.
+func listRows(list []Completion, columns int) []string {
+	width := displayWidth(list) + 2
+	var rows []string
+	if hasDescriptions(list) {
+		for _, c := range list {
+			row := c.display()
+			if c.Description != "" {
+				row += strings.Repeat(" ", width-stringWidth(row)) + c.Description
+			}
+			rows = append(rows, truncateWidth(row, columns-1))
+		}
+		return rows
+	}
+
+	cols := columns / width
+	if cols < 1 {
+		cols = 1
+	}
+	n := (len(list) + cols - 1) / cols
+	for r := 0; r < n; r++ {
+		row := ""
+		for c := 0; c < cols; c++ {
+			i := c*n + r
+			if i >= len(list) {
+				break
+			}
+			row += list[i].display()
+			if c < cols-1 && i+n < len(list) {
+				row += strings.Repeat(" ", width-stringWidth(list[i].display()))
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// completeMenu is the complete command of TabMenu: it shows the candidates in
+// a menu below the prompt, and inserts the one selected in it.
+func (e *Editor) completeMenu() error {
+	s := e.s
+	if s.completer == nil {
+		e.Beep()
+		return nil
+	}
+	// The line as typed, which the candidates complete
+	line, pos := append([]rune(nil), e.line...), e.pos
+	head, list, tail := s.complete(string(line), pos)
+	if len(list) == 0 {
+		e.Beep()
+		return nil
+	}
+	if len(list) == 1 {
+		e.line, e.pos = list[0].apply(head, tail)
+		e.refresh()
+		return nil
+	}
+	defer func() { s.below = nil }()
+
+	sel, offset := 0, 0
+	for {
+		height := s.rows - 1
+		if s.multiRow() {
+			height = s.rows - s.nextRow
+		}
+		height -= len(s.toolbarRows())
+		if s.message != "" {
+			height--
+		}
+		if s.rows <= 0 {
+			height = len(list)
+		}
+		var cols int
+		s.below, offset, cols = menuRows(list, sel, offset, s.columns, height)
+		e.line, e.pos = list[sel].apply(head, tail)
+		e.refresh()
+
+		next, err := e.readKey()
+		if err != nil {
+			return err
+		}
+		n := len(list)
+		switch next {
+		case rune(tab), right:
+			sel = (sel + 1) % n
+		case shiftTab, left:
+			sel = (sel + n - 1) % n
+		case down:
+			if sel+cols < n {
+				sel += cols
+			} else {
+				sel %= cols
+			}
+		case up:
+			if sel >= cols {
+				sel -= cols
+			} else {
+				sel += (n - 1 - sel) / cols * cols
+			}
+		case rune(cr), rune(lf):
+			s.below = nil
+			e.refresh()
+			return nil
+		case rune(esc), rune(ctrlG):
+			s.below = nil
+			e.line, e.pos = line, pos
+			e.refresh()
+			return nil
+		case rune(bs), rune(ctrlH):
+			if pos == 0 {
+				e.Beep()
+				continue
+			}
+			n := prevGrapheme(line, pos)
+			line = append(line[:n], line[pos:]...)
+			pos = n
+		default:
+			r, ok := next.(rune)
+			if !ok || r < ' ' {
+				s.below = nil
+				e.refresh()
+				e.unreadKey(next)
+				return nil
+			}
+			line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+			pos++
+		}
+
+		switch next {
+		case rune(tab), right, shiftTab, left, down, up:
+			continue
+		}
+		// The line as typed changed: narrow the candidates down
+		head, list, tail = s.complete(string(line), pos)
+		sel, offset = 0, 0
+		if len(list) == 0 {
+			s.below = nil
+			e.line, e.pos = line, pos
+			e.refresh()
+			return nil
+		}
+	}
+}
+
+// menuRows lays list out in as many columns as fit in a terminal that is
+// columns wide, or in one column if there are descriptions, with list[sel]
+// highlighted, and returns at most height of the rows. The rows shown start
+// at offset, unless that would hide sel. menuRows also returns the offset
+// used, and the number of columns.
+func menuRows(list []Completion, sel, offset, columns, height int) ([]string, int, int) {
+	avail := columns - 1
+	width := displayWidth(list) + 2 // a space on each side
+	descriptions := hasDescriptions(list)
+	if descriptions {
+		descWidth := 0
+		for _, c := range list {
+			if w := stringWidth(c.Description); w > descWidth {
+				descWidth = w
+			}
+		}
+		width += descWidth + 1
+	}
+	if width > avail {
+		width = avail
+	}
+	if width < 3 {
+		width = 3
+	}
+	cols := avail / width
+	if cols < 1 || descriptions {
+		cols = 1
+	}
+	rows := (len(list) + cols - 1) / cols
+
+	if height < 1 {
+		height = 1
+	}
+	if selRow := sel / cols; selRow < offset {
+		offset = selRow
+	} else if selRow >= offset+height {
+		offset = selRow - height + 1
+	}
+
+	dw := displayWidth(list)
+	var menu []string
+	for r := offset; r < rows && r < offset+height; r++ {
+		row := ""
+		for c := 0; c < cols; c++ {
+			i := r*cols + c
+			if i >= len(list) {
+				break
+			}
+			item := list[i].display()
+			if descriptions && list[i].Description != "" {
+				item += strings.Repeat(" ", dw-stringWidth(item)+1) + list[i].Description
+			}
+			item = truncateWidth(item, width-2)
+			cell := " " + item + strings.Repeat(" ", width-1-stringWidth(item))
+			if i == sel {
+				cell = "\x1b[7m" + cell + "\x1b[0m"
+			}
+			row += cell
+		}
+		menu = append(menu, row)
+	}
+	return menu, offset, cols
+}
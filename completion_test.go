@@ -0,0 +1,86 @@
+// +build windows linux darwin openbsd freebsd netbsd
+
+package liner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLongestCommonPrefix(t *testing.T) {
+	tests := []struct {
+		list     []string
+		expected string
+	}{
+		{nil, ""},
+		{[]string{"table"}, "table"},
+		{[]string{"table1", "table2", "tab"}, "tab"},
+		{[]string{"été", "éta"}, "ét"},
+		{[]string{"abc", "xyz"}, ""},
+	}
+	for _, test := range tests {
+		if prefix := longestCommonPrefix(test.list); prefix != test.expected {
+			t.Errorf("Expected %q for %q, got %q", test.expected, test.list, prefix)
+		}
+	}
+}
+
+func TestMenuRows(t *testing.T) {
+	list := completions([]string{"one", "two", "three", "four", "five"})
+	rows, offset, cols := menuRows(list, 1, 0, 16, 10)
+	expected := []string{" one   \x1b[7m two   \x1b[0m", " three  four  ", " five  "}
+	if !reflect.DeepEqual(rows, expected) || offset != 0 || cols != 2 {
+		t.Fatalf("Unexpected menu %q, offset %d, %d columns", rows, offset, cols)
+	}
+
+	// Scroll to keep the selection visible
+	rows, offset, _ = menuRows(list, 4, 0, 10, 2)
+	expected = []string{" four  ", "\x1b[7m five  \x1b[0m"}
+	if !reflect.DeepEqual(rows, expected) || offset != 3 {
+		t.Fatalf("Unexpected menu %q, offset %d", rows, offset)
+	}
+	rows, offset, _ = menuRows(list, 0, offset, 10, 2)
+	if len(rows) != 2 || offset != 0 {
+		t.Fatalf("Unexpected menu %q, offset %d", rows, offset)
+	}
+}
+
+func TestCompletionApply(t *testing.T) {
+	tests := []struct {
+		c          Completion
+		head, tail string
+		line       string
+		pos        int
+	}{
+		{Completion{Text: "foo"}, "cd ", "", "cd foo", 6},
+		{Completion{Text: "foo", Suffix: "/"}, "cd ", "", "cd foo/", 7},
+		{Completion{Text: "foo", Suffix: "/"}, "cd ", "/bar", "cd foo/bar", 7},
+		{Completion{Text: "été", Suffix: " "}, "", "x", "été x", 4},
+	}
+	for _, test := range tests {
+		line, pos := test.c.apply(test.head, test.tail)
+		if string(line) != test.line || pos != test.pos {
+			t.Errorf("Expected %q at %d for %+v, got %q at %d", test.line, test.pos, test.c, string(line), pos)
+		}
+	}
+}
+
+func TestDescriptionRows(t *testing.T) {
+	list := []Completion{
+		{Text: "SELECT", Description: "query rows"},
+		{Text: "SET", Display: "SET option", Description: "change a setting"},
+		{Text: "SHOW"},
+	}
+	expected := []string{"SELECT      query rows", "SET option  change a se", "SHOW"}
+	if rows := listRows(list, 24); !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Unexpected list %q", rows)
+	}
+	expected = []string{
+		" SELECT     query rows       ",
+		"\x1b[7m SET option change a setting \x1b[0m",
+		" SHOW                        ",
+	}
+	if rows, _, cols := menuRows(list, 1, 0, 80, 10); !reflect.DeepEqual(rows, expected) || cols != 1 {
+		t.Errorf("Unexpected menu %q", rows)
+	}
+}
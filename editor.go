@@ -0,0 +1,667 @@
+// +build windows linux darwin openbsd freebsd netbsd
+
+package liner
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Editor is the line being edited by Prompt. It is passed to the KeyHandler
+// bound to a key sequence, so that the handler can inspect or change the line.
+// An Editor must not be used after the handler returns.
+type Editor struct {
+	s          *State
+	prompt     string // as passed to Prompt
+	p          string // as displayed
+	promptFunc func() string
+	line       []rune
+	pos        int
+	accepted   bool
+
+	historyEnd    string
+	prefixHistory []string
+	historyPos    int
+	historyAction bool // used to mark history related actions
+	killAction    int  // used to mark kill related actions
+
+	undoStack    []undoEntry
+	redoStack    []undoEntry
+	inserted     bool // the last command inserted a character
+	lastInserted bool // the command before it did too
+	undoAction   bool // used to mark commands that keep the undo stacks themselves
+	viInsert     bool // the changes are part of a vi insert
+	viMode       ViMode
+
+	keys []interface{} // keys read ahead, to be handled before reading more
+
+	searchPattern  string
+	searchBackward bool
+	searchPos      int // index in history of the last match
+}
+
+// editorConfig holds the settings of State that change how Prompt edits, and
+// the Editor of the prompt in progress.
+type editorConfig struct {
+	keymap     *Keymap
+	viMode     bool
+	viModeHook func(mode ViMode, prompt string) string
+	editor     *Editor
+}
+
+// Line returns the contents of the line being edited.
+func (e *Editor) Line() string {
+	return string(e.line)
+}
+
+// Pos returns the position of the cursor, in runes from the start of the line.
+func (e *Editor) Pos() int {
+	return e.pos
+}
+
+// SetLine replaces the line being edited with line, and moves the cursor to
+// pos. A pos outside of line moves the cursor to the nearest end of it.
+func (e *Editor) SetLine(line string, pos int) {
+	e.line = []rune(line)
+	switch {
+	case pos < 0:
+		e.pos = 0
+	case pos > len(e.line):
+		e.pos = len(e.line)
+	default:
+		e.pos = pos
+	}
+}
+
+// Insert inserts text at the cursor, and moves the cursor past it.
+func (e *Editor) Insert(text string) {
+	r := []rune(text)
+	e.line = append(e.line[:e.pos], append(r, e.line[e.pos:]...)...)
+	e.pos += len(r)
+}
+
+// Accept finishes the prompt once the handler returns. Prompt returns the
+// line as the handler left it.
+func (e *Editor) Accept() {
+	e.accepted = true
+}
+
+// Beep rings the terminal bell.
+func (e *Editor) Beep() {
+	fmt.Fprint(e.s.w, beep)
+}
+
+// setPrompt replaces the prompt passed to Prompt with p.
+func (e *Editor) setPrompt(p string) {
+	e.prompt, e.p = p, p
+	if e.s.viMode {
+		e.setViMode(e.viMode)
+	}
+}
+
+func (e *Editor) refresh() {
+	e.s.suggestion = e.suggestion()
+	e.s.refresh(e.p, string(e.line), e.pos)
+	e.s.suggestion = ""
+}
+
+// suggestion returns the part of the suggested line that follows the line.
+func (e *Editor) suggestion() string {
+	if e.s.suggester == nil || len(e.line) == 0 {
+		return ""
+	}
+	line := string(e.line)
+	var suggested string
+	e.s.unlocked(func() { suggested = e.s.suggester(line) })
+	if len(suggested) <= len(line) || !strings.HasPrefix(suggested, line) {
+		return ""
+	}
+	return suggested[len(line):]
+}
+
+// acceptSuggestion appends the suggestion to the line, up to the end of its
+// first word if word is true, and reports whether there was a suggestion.
+func (e *Editor) acceptSuggestion(word bool) bool {
+	if e.pos < len(e.line) {
+		return false
+	}
+	suggestion := []rune(e.suggestion())
+	if len(suggestion) == 0 {
+		return false
+	}
+	line := append(e.line, suggestion...)
+	end := len(line)
+	if word {
+		end = e.pos
+		for {
+			end = nextGrapheme(line, end)
+			if end == len(line) || unicode.IsSpace(line[end]) {
+				break
+			}
+		}
+	}
+	e.line = line[:end]
+	e.pos = end
+	e.refresh()
+	return true
+}
+
+// readKey returns the next key, from the keys read ahead if there are any.
+func (e *Editor) readKey() (interface{}, error) {
+	if len(e.keys) > 0 {
+		next := e.keys[0]
+		e.keys = e.keys[1:]
+		return next, nil
+	}
+	return e.s.nextKey()
+}
+
+// unreadKey makes next the next key returned by readKey.
+func (e *Editor) unreadKey(next interface{}) {
+	e.keys = append([]interface{}{next}, e.keys...)
+}
+
+// readCommand reads keys until they form a sequence that is bound in km, or
+// a sequence that can't become one, and returns the command to run.
+func (e *Editor) readCommand(km *Keymap) (editCommand, error) {
+	b := &km.root
+	for {
+		next, err := e.readKey()
+		if err != nil {
+			return nil, err
+		}
+		if a, ok := next.(action); ok && a == winch {
+			return (*Editor).redraw, nil
+		}
+		child := b.children[next]
+		if child == nil {
+			if b == &km.root {
+				return unboundCommand(next), nil
+			}
+			// The sequence typed so far stops here. Run what it is bound
+			// to by itself, and handle next on its own.
+			e.unreadKey(next)
+			if b.command != nil {
+				return b.command, nil
+			}
+			return (*Editor).ding, nil
+		}
+		if len(child.children) == 0 {
+			return child.command, nil
+		}
+		b = child
+	}
+}
+
+// unboundCommand returns the command run when next is typed without being
+// bound: printable characters and pasted text are inserted, control
+// characters beep, and other keys redraw the line.
+func unboundCommand(next interface{}) editCommand {
+	if text, ok := next.(pastedText); ok {
+		return func(e *Editor) error {
+			return e.insertPaste(string(text))
+		}
+	}
+	r, ok := next.(rune)
+	switch {
+	case !ok:
+		return (*Editor).redraw
+	case r < ' ' || r == bs:
+		return (*Editor).ding
+	}
+	return func(e *Editor) error {
+		return e.selfInsert(r)
+	}
+}
+
+type undoEntry struct {
+	line []rune
+	pos  int
+}
+
+func (e *Editor) snapshot() undoEntry {
+	return undoEntry{append([]rune(nil), e.line...), e.pos}
+}
+
+// trackUndo records the line as it was before a command, if the command
+// changed it. Consecutive inserted characters are undone together.
+func (e *Editor) trackUndo(before undoEntry) {
+	if e.undoAction {
+		e.lastInserted = false
+		return
+	}
+	if string(before.line) != string(e.line) {
+		if !(e.inserted && e.lastInserted) && !e.viInsert {
+			e.undoStack = append(e.undoStack, before)
+		}
+		e.redoStack = nil
+	}
+	e.lastInserted = e.inserted
+}
+
+func (e *Editor) resetHistory() {
+	e.prefixHistory = e.s.getHistoryByPrefix(string(e.line))
+	e.historyPos = len(e.prefixHistory)
+}
+
+func (e *Editor) selfInsert(r rune) error {
+	s := e.s
+	e.inserted = true
+	if e.pos == len(e.line) && !s.multiRow() && s.suggester == nil && s.highlighter == nil && s.rightPrompt == "" &&
+		stringWidth(stripAnsiColorSequences(e.p))+stringWidth(showControls(string(e.line)+string(r))) < s.columns {
+		e.line = append(e.line, r)
+		fmt.Fprint(s.w, showControls(string(r)))
+		e.pos++
+		s.shownBuf, s.shownPos = string(e.line), e.pos
+	} else {
+		e.line = append(e.line[:e.pos], append([]rune{r}, e.line[e.pos:]...)...)
+		e.pos++
+		e.refresh()
+	}
+	return nil
+}
+
+// insertPaste inserts text pasted into the terminal.
+func (e *Editor) insertPaste(text string) error {
+	text, ok := e.s.pasteText(text)
+	if !ok {
+		e.Beep()
+		return nil
+	}
+	e.Insert(text)
+	e.refresh()
+	return nil
+}
+
+// acceptLine accepts the line, unless the validator rejects it. If multi-line
+// input is on and the input is not complete, it inserts a newline instead.
+func (e *Editor) acceptLine() error {
+	if complete := e.s.inputComplete; complete != nil && !complete(string(e.line)) {
+		e.Insert("\n")
+		e.refresh()
+		return nil
+	}
+	if validate := e.s.validator; validate != nil {
+		var err error
+		e.s.unlocked(func() { err = validate(string(e.line)) })
+		if err != nil {
+			e.s.message = err.Error()
+			if verr, ok := err.(*ValidationError); ok {
+				e.SetLine(string(e.line), verr.Pos)
+			}
+			e.refresh()
+			e.Beep()
+			return nil
+		}
+	}
+	e.accepted = true
+	return nil
+}
+
+func (e *Editor) ignore() error {
+	return nil
+}
+
+func (e *Editor) ding() error {
+	e.Beep()
+	return nil
+}
+
+func (e *Editor) redraw() error {
+	e.refresh()
+	return nil
+}
+
+func (e *Editor) beginningOfLine() error {
+	e.pos = 0
+	e.refresh()
+	return nil
+}
+
+func (e *Editor) endOfLine() error {
+	e.pos = len(e.line)
+	if e.acceptSuggestion(false) {
+		return nil
+	}
+	e.refresh()
+	return nil
+}
+
+func (e *Editor) backwardChar() error {
+	if e.pos > 0 {
+		e.pos = prevGrapheme(e.line, e.pos)
+		e.refresh()
+	} else {
+		e.Beep()
+	}
+	return nil
+}
+
+func (e *Editor) forwardChar() error {
+	if e.acceptSuggestion(false) {
+		return nil
+	}
+	if e.pos < len(e.line) {
+		e.pos = nextGrapheme(e.line, e.pos)
+		e.refresh()
+	} else {
+		e.Beep()
+	}
+	return nil
+}
+
+func (e *Editor) backwardWord() error {
+	if e.pos > 0 {
+		for {
+			e.pos = prevGrapheme(e.line, e.pos)
+			if e.pos == 0 || unicode.IsSpace(e.line[e.pos-1]) {
+				break
+			}
+		}
+		e.refresh()
+	} else {
+		e.Beep()
+	}
+	return nil
+}
+
+func (e *Editor) forwardWord() error {
+	if e.acceptSuggestion(true) {
+		return nil
+	}
+	if e.pos < len(e.line) {
+		for {
+			e.pos = nextGrapheme(e.line, e.pos)
+			if e.pos == len(e.line) || unicode.IsSpace(e.line[e.pos]) {
+				break
+			}
+		}
+		e.refresh()
+	} else {
+		e.Beep()
+	}
+	return nil
+}
+
+func (e *Editor) deleteChar() error {
+	if e.pos >= len(e.line) {
+		e.Beep()
+	} else {
+		e.line = append(e.line[:e.pos], e.line[nextGrapheme(e.line, e.pos):]...)
+		e.refresh()
+	}
+	return nil
+}
+
+func (e *Editor) deleteCharOrEOF() error {
+	if e.pos == 0 && len(e.line) == 0 {
+		return io.EOF
+	}
+	return e.deleteChar()
+}
+
+func (e *Editor) backwardDeleteChar() error {
+	if e.pos <= 0 {
+		e.Beep()
+	} else {
+		n := prevGrapheme(e.line, e.pos)
+		e.line = append(e.line[:n], e.line[e.pos:]...)
+		e.pos = n
+		e.refresh()
+	}
+	return nil
+}
+
+func (e *Editor) transposeChars() error {
+	mid := e.pos
+	if mid == len(e.line) && mid > 0 {
+		mid = prevGrapheme(e.line, mid)
+	}
+	if mid < 1 {
+		e.Beep()
+	} else {
+		start, end := prevGrapheme(e.line, mid), nextGrapheme(e.line, mid)
+		swapped := append(append([]rune{}, e.line[mid:end]...), e.line[start:mid]...)
+		copy(e.line[start:end], swapped)
+		e.pos = end
+		e.refresh()
+	}
+	return nil
+}
+
+func (e *Editor) killLine() error {
+	if e.pos >= len(e.line) {
+		e.Beep()
+		return nil
+	}
+	if e.killAction > 0 {
+		e.s.addToKillRing(e.line[e.pos:], 1) // Add in apend mode
+	} else {
+		e.s.addToKillRing(e.line[e.pos:], 0) // Add in normal mode
+	}
+
+	e.killAction = 2 // Mark that there was a kill action
+	e.line = e.line[:e.pos]
+	e.refresh()
+	return nil
+}
+
+func (e *Editor) unixLineDiscard() error {
+	if e.killAction > 0 {
+		e.s.addToKillRing(e.line[:e.pos], 2) // Add in prepend mode
+	} else {
+		e.s.addToKillRing(e.line[:e.pos], 0) // Add in normal mode
+	}
+
+	e.killAction = 2 // Mark that there was some killing
+	e.line = e.line[e.pos:]
+	e.pos = 0
+	e.refresh()
+	return nil
+}
+
+func (e *Editor) unixWordRubout() error {
+	if e.pos == 0 {
+		e.Beep()
+		return nil
+	}
+	end := e.pos
+	// Remove whitespace to the left
+	for e.pos > 0 && unicode.IsSpace(e.line[e.pos-1]) {
+		e.pos--
+	}
+	// Remove non-whitespace to the left
+	for e.pos > 0 && !unicode.IsSpace(e.line[e.pos-1]) {
+		e.pos--
+	}
+	// Save the deleted chars on the killRing
+	if e.killAction > 0 {
+		e.s.addToKillRing(e.line[e.pos:end], 2) // Add in prepend mode
+	} else {
+		e.s.addToKillRing(e.line[e.pos:end], 0) // Add in normal mode
+	}
+	e.killAction = 2 // Mark that there was some killing
+	e.line = append(e.line[:e.pos], e.line[end:]...)
+
+	e.refresh()
+	return nil
+}
+
+// moveRow moves the cursor to the same column of the row of the input above
+// it, or below it if down is set, and reports whether there is such a row.
+func (e *Editor) moveRow(down bool) bool {
+	start := e.pos
+	for start > 0 && e.line[start-1] != '\n' {
+		start--
+	}
+	col := runesWidth(e.line[start:e.pos])
+	var from int
+	if down {
+		end := e.pos
+		for end < len(e.line) && e.line[end] != '\n' {
+			end++
+		}
+		if end == len(e.line) {
+			return false
+		}
+		from = end + 1
+	} else {
+		if start == 0 {
+			return false
+		}
+		from = start - 1
+		for from > 0 && e.line[from-1] != '\n' {
+			from--
+		}
+	}
+	pos := from
+	for pos < len(e.line) && e.line[pos] != '\n' {
+		next := nextGrapheme(e.line, pos)
+		if runesWidth(e.line[from:next]) > col {
+			break
+		}
+		pos = next
+	}
+	e.pos = pos
+	e.refresh()
+	return true
+}
+
+func (e *Editor) previousHistory() error {
+	e.historyAction = true
+	if e.moveRow(false) {
+		return nil
+	}
+	if e.historyPos > 0 {
+		if e.historyPos == len(e.prefixHistory) {
+			e.historyEnd = string(e.line)
+		}
+		e.historyPos--
+		e.line = []rune(e.prefixHistory[e.historyPos])
+		e.pos = len(e.line)
+		e.refresh()
+	} else {
+		e.Beep()
+	}
+	return nil
+}
+
+func (e *Editor) nextHistory() error {
+	e.historyAction = true
+	if e.moveRow(true) {
+		return nil
+	}
+	if e.historyPos < len(e.prefixHistory) {
+		e.historyPos++
+		if e.historyPos == len(e.prefixHistory) {
+			e.line = []rune(e.historyEnd)
+		} else {
+			e.line = []rune(e.prefixHistory[e.historyPos])
+		}
+		e.pos = len(e.line)
+		e.refresh()
+	} else {
+		e.Beep()
+	}
+	return nil
+}
+
+func (e *Editor) clearScreen() error {
+	e.s.eraseScreen()
+	e.s.cursorRow = 0
+	e.refresh()
+	return nil
+}
+
+func (e *Editor) interrupt() error {
+	switch e.s.ctrlCMode {
+	case CtrlCAbort:
+		e.s.moveBelow()
+		return ErrPromptAborted
+	case CtrlCClear:
+		e.s.moveBelow()
+		e.line = nil
+		e.pos = 0
+		e.s.printPrompt(e.p)
+	default:
+		e.Beep()
+	}
+	return nil
+}
+
+func (e *Editor) undo() error {
+	e.undoAction = true
+	n := len(e.undoStack)
+	if n == 0 {
+		e.Beep()
+		return nil
+	}
+	e.redoStack = append(e.redoStack, e.snapshot())
+	e.line, e.pos = e.undoStack[n-1].line, e.undoStack[n-1].pos
+	e.undoStack = e.undoStack[:n-1]
+	e.refresh()
+	return nil
+}
+
+func (e *Editor) redo() error {
+	e.undoAction = true
+	n := len(e.redoStack)
+	if n == 0 {
+		e.Beep()
+		return nil
+	}
+	e.undoStack = append(e.undoStack, e.snapshot())
+	e.line, e.pos = e.redoStack[n-1].line, e.redoStack[n-1].pos
+	e.redoStack = e.redoStack[:n-1]
+	e.refresh()
+	return nil
+}
+
+func (e *Editor) complete() error {
+	switch e.s.tabStyle {
+	case TabPrints:
+		return e.completeList()
+	case TabMenu:
+		return e.completeMenu()
+	}
+	line, pos, next, err := e.s.tabComplete(e.p, e.line, e.pos)
+	if err != nil {
+		return err
+	}
+	if key, ok := next.(rune); ok && key == tab {
+		// Nothing to complete
+		e.Beep()
+		return nil
+	}
+	e.line, e.pos = line, pos
+	e.refresh()
+	e.unreadKey(next)
+	return nil
+}
+
+func (e *Editor) yank() error {
+	if e.s.killRing == nil {
+		e.Beep()
+		return nil
+	}
+	line, pos, next, err := e.s.yank(e.p, e.line, e.pos)
+	if err != nil {
+		return err
+	}
+	e.line, e.pos = line, pos
+	e.unreadKey(next)
+	return nil
+}
+
+func (e *Editor) reverseSearchHistory() error {
+	line, pos, next, err := e.s.reverseISearch(e.line, e.pos)
+	if err != nil {
+		return err
+	}
+	e.line, e.pos = line, pos
+	e.refresh()
+	e.unreadKey(next)
+	return nil
+}
@@ -0,0 +1,9 @@
+// +build !windows,!linux,!darwin,!openbsd,!freebsd,!netbsd
+
+package liner
+
+// lockFile does nothing on this operating system, where files are not
+// locked.
+func lockFile(path string, exclusive bool) (func(), error) {
+	return func() {}, nil
+}
@@ -5,9 +5,12 @@ package liner
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 )
 
 // State represents an open terminal
@@ -19,7 +22,7 @@ type State struct {
 // Prompt displays p, and then waits for user input. Prompt does not support
 // line editing on this operating system.
 func (s *State) Prompt(p string) (string, error) {
-	fmt.Print(p)
+	fmt.Fprint(s.w, p)
 	linebuf, _, err := s.r.ReadLine()
 	if err != nil {
 		return "", err
@@ -27,6 +30,44 @@ func (s *State) Prompt(p string) (string, error) {
 	return string(bytes.TrimSpace(linebuf)), nil
 }
 
+// PromptContext displays p, and then waits for user input. On this operating
+// system, ctx is only checked before p is displayed.
+func (s *State) PromptContext(ctx context.Context, p string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return s.Prompt(p)
+}
+
+// printAbove prints text, on rows of its own. Nothing needs to be drawn again
+// on this operating system, because the input is not edited.
+func (s *State) printAbove(text string) error {
+	s.outputMutex.Lock()
+	defer s.outputMutex.Unlock()
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	_, err := fmt.Fprint(s.w, text)
+	return err
+}
+
+// PromptWithDefault displays p, and then waits for user input. As the input
+// is not edited on this operating system, text and pos are ignored.
+func (s *State) PromptWithDefault(p string, text string, pos int) (string, error) {
+	return s.Prompt(p)
+}
+
+// PromptFunc displays the prompt returned by f, and then waits for user
+// input.
+func (s *State) PromptFunc(f func() string) (string, error) {
+	return s.Prompt(f())
+}
+
+// RefreshPrompt does nothing on this operating system, as the prompt is not
+// drawn again.
+func (s *State) RefreshPrompt() {
+}
+
 // PasswordPrompt is not supported in this OS.
 func (s *State) PasswordPrompt(p string) (string, error) {
 	return "", errors.New("liner: function not supported in this terminal")
@@ -39,6 +80,24 @@ func (s *State) PasswordPrompt(p string) (string, error) {
 func NewLiner() *State {
 	var s State
 	s.r = bufio.NewReader(os.Stdin)
+	s.w = os.Stdout
+	return &s
+}
+
+// NewLinerWithConfig initializes a new *State that reads from and writes to
+// the streams described by cfg. Terminal and TermSize are ignored, as line
+// editing is not supported on this operating system.
+func NewLinerWithConfig(cfg Config) *State {
+	var s State
+	var in io.Reader = os.Stdin
+	if cfg.Input != nil {
+		in = cfg.Input
+	}
+	s.r = bufio.NewReader(in)
+	s.w = os.Stdout
+	if cfg.Output != nil {
+		s.w = cfg.Output
+	}
 	return &s
 }
 
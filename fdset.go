@@ -0,0 +1,18 @@
+// +build linux darwin openbsd netbsd
+
+package liner
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func setFd(set *syscall.FdSet, fd int) {
+	bits := 8 * int(unsafe.Sizeof(set.Bits[0]))
+	set.Bits[fd/bits] |= 1 << uint(fd%bits)
+}
+
+func fdIsSet(set *syscall.FdSet, fd int) bool {
+	bits := 8 * int(unsafe.Sizeof(set.Bits[0]))
+	return set.Bits[fd/bits]&(1<<uint(fd%bits)) != 0
+}
@@ -0,0 +1,18 @@
+// +build freebsd
+
+package liner
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func setFd(set *syscall.FdSet, fd int) {
+	bits := 8 * int(unsafe.Sizeof(set.X__fds_bits[0]))
+	set.X__fds_bits[fd/bits] |= 1 << uint(fd%bits)
+}
+
+func fdIsSet(set *syscall.FdSet, fd int) bool {
+	bits := 8 * int(unsafe.Sizeof(set.X__fds_bits[0]))
+	return set.X__fds_bits[fd/bits]&(1<<uint(fd%bits)) != 0
+}
@@ -0,0 +1,69 @@
+package liner
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGraphemeBreak(t *testing.T) {
+	f, err := os.Open("testdata/GraphemeBreakTest.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	num := 0
+	for scanner.Scan() {
+		num++
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var line []rune
+		var breaks []bool
+		for _, field := range fields {
+			switch field {
+			case "÷", "×":
+				breaks = append(breaks, field == "÷")
+			default:
+				r, err := strconv.ParseUint(field, 16, 32)
+				if err != nil {
+					t.Fatalf("line %d: %s", num, err)
+				}
+				line = append(line, rune(r))
+			}
+		}
+		for i := range breaks {
+			if graphemeBreak(line, i) != breaks[i] {
+				t.Errorf("line %d: expected break %v before index %d of %q",
+					num, breaks[i], i, string(line))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGraphemeSteps(t *testing.T) {
+	// flag, e + combining acute, family ZWJ sequence, x
+	line := []rune("\U0001f1ef\U0001f1f5e\u0301\U0001f468\u200d\U0001f469\u200d\U0001f467x")
+	stops := []int{0, 2, 4, 9, 10}
+	for i := 0; i < len(stops)-1; i++ {
+		if next := nextGrapheme(line, stops[i]); next != stops[i+1] {
+			t.Errorf("Expected next cluster after %d at %d, got %d", stops[i], stops[i+1], next)
+		}
+		if prev := prevGrapheme(line, stops[i+1]); prev != stops[i] {
+			t.Errorf("Expected cluster before %d at %d, got %d", stops[i+1], stops[i], prev)
+		}
+	}
+}
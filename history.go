@@ -0,0 +1,454 @@
+package liner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// HistoryStore stores the entries of the scrollback history. Prompt consults
+// it for Up and Down, prefix navigation, suggestions and Ctrl-R, and
+// AppendHistory, ReadHistory and WriteHistory add to it and read from it. A
+// State uses a MemoryHistory unless SetHistoryStore is called.
+//
+// The methods of a HistoryStore may be called from several goroutines.
+type HistoryStore interface {
+	// Append adds entry as the most recent entry.
+	Append(entry string) error
+	// Iterate calls f with each entry, from the most recent to the
+	// oldest, until f returns false.
+	Iterate(f func(entry string) bool) error
+	// Search returns the entries that contain pattern, from the most
+	// recent to the oldest.
+	Search(pattern string) ([]string, error)
+	// Delete removes every entry equal to entry.
+	Delete(entry string) error
+}
+
+// MemoryHistory is a HistoryStore that keeps the entries in memory. The zero
+// value is an empty history.
+type MemoryHistory struct {
+	// Limit is the maximum number of entries kept, after which the oldest
+	// are dropped. If it is 0, HistoryLimit is used.
+	Limit int
+
+	mutex   sync.RWMutex
+	entries []string
+}
+
+// SetLimit sets Limit, and drops the oldest entries beyond it. Unlike
+// setting Limit, it is safe to call while the history is in use.
+func (h *MemoryHistory) SetLimit(n int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.Limit = n
+	if n > 0 && len(h.entries) > n {
+		h.entries = h.entries[len(h.entries)-n:]
+	}
+}
+
+// Append adds entry as the most recent entry, and drops the oldest if there
+// are more entries than the limit.
+func (h *MemoryHistory) Append(entry string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.add(entry)
+	return nil
+}
+
+// add appends entry, and drops the oldest entries beyond the limit. It must
+// be called with h.mutex held.
+func (h *MemoryHistory) add(entry string) {
+	h.entries = append(h.entries, entry)
+	limit := h.Limit
+	if limit <= 0 {
+		limit = HistoryLimit
+	}
+	if len(h.entries) > limit {
+		h.entries = h.entries[len(h.entries)-limit:]
+	}
+}
+
+// Iterate calls f with each entry, from the most recent to the oldest, until
+// f returns false.
+func (h *MemoryHistory) Iterate(f func(entry string) bool) error {
+	h.mutex.RLock()
+	entries := h.entries
+	h.mutex.RUnlock()
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !f(entries[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+// Search returns the entries that contain pattern, from the most recent to
+// the oldest.
+func (h *MemoryHistory) Search(pattern string) ([]string, error) {
+	var found []string
+	h.Iterate(func(entry string) bool {
+		if strings.Contains(entry, pattern) {
+			found = append(found, entry)
+		}
+		return true
+	})
+	return found, nil
+}
+
+// Delete removes every entry equal to entry.
+func (h *MemoryHistory) Delete(entry string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.remove(entry)
+	return nil
+}
+
+// remove removes every entry equal to entry, and reports whether there were
+// any. It must be called with h.mutex held.
+func (h *MemoryHistory) remove(entry string) bool {
+	// Iterate may still be reading the old slice
+	kept := make([]string, 0, len(h.entries))
+	for _, e := range h.entries {
+		if e != entry {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == len(h.entries) {
+		return false
+	}
+	h.entries = kept
+	return true
+}
+
+// appendErasingDups removes the entries equal to entry, and then appends it.
+func (h *MemoryHistory) appendErasingDups(entry string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.remove(entry)
+	h.add(entry)
+	return nil
+}
+
+// HistoryMerger is implemented by a HistoryStore whose entries can be added
+// to by other processes, such as FileHistory. Prompt calls Merge before
+// displaying the prompt, so that the entries they added can be recalled.
+type HistoryMerger interface {
+	// Merge adds the entries added by other processes since the last
+	// call.
+	Merge() error
+}
+
+// FileHistory is a HistoryStore that appends each entry to a file, one per
+// line, as soon as it is added, with its newlines and backslashes written as
+// \n and \\. Several processes can share the file: it is written while
+// holding an advisory lock on a file next to it, named with ".lock" appended,
+// and the entries added by other processes are merged in before each prompt.
+// When the file has twice as many entries as the limit, it is compacted to
+// the most recent ones, by replacing it with a new file.
+//
+// The entries are kept in memory as well, so that the file is only read when
+// it changes.
+type FileHistory struct {
+	mem    MemoryHistory
+	path   string
+	mutex  sync.Mutex  // held while the file is read or written
+	info   os.FileInfo // of the file when it was last read
+	offset int64       // bytes of the file read
+	lines  int         // entries in the file
+}
+
+// OpenFileHistory returns a FileHistory that stores the entries in the file
+// at path, starting with those already in it. The file is created when the
+// first entry is added, if it doesn't exist.
+func OpenFileHistory(path string) (*FileHistory, error) {
+	h := &FileHistory{path: path}
+	if err := h.Merge(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// SetLimit sets the maximum number of entries kept, after which the oldest
+// are dropped. If n is 0, HistoryLimit is used.
+func (h *FileHistory) SetLimit(n int) {
+	h.mem.SetLimit(n)
+}
+
+// Merge adds the entries appended to the file by other processes since it
+// was last read. If the file was replaced, for example because another
+// process compacted it, all of its entries are read again.
+func (h *FileHistory) Merge() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	unlock, err := lockFile(h.path+".lock", false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return h.merge()
+}
+
+// merge reads the entries added to the file since it was last read. It must
+// be called with h.mutex held and the lock file locked.
+func (h *FileHistory) merge() error {
+	f, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		h.info, h.offset, h.lines = nil, 0, 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if h.info == nil || !os.SameFile(info, h.info) || info.Size() < h.offset {
+		h.mem.mutex.Lock()
+		h.mem.entries = nil
+		h.mem.mutex.Unlock()
+		h.offset, h.lines = 0, 0
+	}
+	h.info = info
+	if info.Size() == h.offset {
+		return nil
+	}
+	if _, err := f.Seek(h.offset, 0); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	// Only whole lines are read; the rest is read once it is complete
+	data = data[:bytes.LastIndexByte(data, '\n')+1]
+	decodeEntries(data, func(entry string) {
+		h.lines++
+		h.mem.Append(entry)
+	})
+	h.offset += int64(len(data))
+	return nil
+}
+
+// Append adds entry as the most recent entry, and appends it to the file.
+func (h *FileHistory) Append(entry string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	unlock, err := lockFile(h.path+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := h.merge(); err != nil {
+		return err
+	}
+	return h.append(entry)
+}
+
+// append appends entry to the file, and compacts it if it has become too
+// long. It must be called with h.mutex held and the lock file locked for
+// writing.
+func (h *FileHistory) append(entry string) error {
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	n, err := fmt.Fprintln(f, entryEscaper.Replace(entry))
+	if err == nil {
+		h.info, err = f.Stat()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	h.mem.Append(entry)
+	h.offset += int64(n)
+	h.lines++
+	if h.lines >= 2*h.limit() {
+		return h.compact()
+	}
+	return nil
+}
+
+// Iterate calls f with each entry, from the most recent to the oldest, until
+// f returns false.
+func (h *FileHistory) Iterate(f func(entry string) bool) error {
+	return h.mem.Iterate(f)
+}
+
+// Search returns the entries that contain pattern, from the most recent to
+// the oldest.
+func (h *FileHistory) Search(pattern string) ([]string, error) {
+	return h.mem.Search(pattern)
+}
+
+// Delete removes every entry equal to entry, and replaces the file with one
+// without them if there were any.
+func (h *FileHistory) Delete(entry string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	unlock, err := lockFile(h.path+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := h.merge(); err != nil {
+		return err
+	}
+	h.mem.mutex.Lock()
+	removed := h.mem.remove(entry)
+	h.mem.mutex.Unlock()
+	if !removed {
+		return nil
+	}
+	return h.compact()
+}
+
+// appendErasingDups removes the entries equal to entry and appends it while
+// holding the lock once, so that other processes see either change or
+// neither. The file is only replaced if there were any.
+func (h *FileHistory) appendErasingDups(entry string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	unlock, err := lockFile(h.path+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := h.merge(); err != nil {
+		return err
+	}
+	h.mem.mutex.Lock()
+	removed := h.mem.remove(entry)
+	if removed {
+		h.mem.add(entry)
+	}
+	h.mem.mutex.Unlock()
+	if !removed {
+		return h.append(entry)
+	}
+	return h.compact()
+}
+
+func (h *FileHistory) limit() int {
+	h.mem.mutex.RLock()
+	defer h.mem.mutex.RUnlock()
+	if h.mem.Limit <= 0 {
+		return HistoryLimit
+	}
+	return h.mem.Limit
+}
+
+// compact replaces the file with one that has only the entries kept in
+// memory. The new file is written beside it and renamed over it, so that
+// other processes read either file whole. It must be called with h.mutex
+// held and the lock file locked for writing.
+func (h *FileHistory) compact() error {
+	dir, name := filepath.Split(h.path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	entries := historyEntries(&h.mem)
+	w := bufio.NewWriter(f)
+	for _, e := range entries {
+		fmt.Fprintln(w, entryEscaper.Replace(e))
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Chmod(0600)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), h.path)
+	}
+	if err != nil {
+		return err
+	}
+	h.info, err = os.Stat(h.path)
+	if err != nil {
+		return err
+	}
+	h.offset, h.lines = h.info.Size(), len(entries)
+	return nil
+}
+
+// readEntries calls add with each line of r.
+func readEntries(r io.Reader, add func(entry string) error) error {
+	in := bufio.NewReader(r)
+	for num := 1; ; num++ {
+		line, part, err := in.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if part {
+			return fmt.Errorf("line %d is too long", num)
+		}
+		if !utf8.Valid(line) {
+			return fmt.Errorf("invalid string at line %d", num)
+		}
+		if err := add(string(line)); err != nil {
+			return err
+		}
+	}
+}
+
+// entryEscaper escapes the newlines in the entries of a FileHistory, and the
+// backslashes that would make them ambiguous; entryUnescaper undoes it.
+var (
+	entryEscaper   = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	entryUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+)
+
+// decodeEntries calls add with each entry in data, which holds whole lines of
+// a FileHistory file. Unlike readEntries, it has no limit on the length of a
+// line, and it replaces invalid UTF-8 with U+FFFD rather than fail, so that
+// one damaged line doesn't stop the history from being shared.
+func decodeEntries(data []byte, add func(entry string)) {
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		line := data[:i]
+		data = data[i+1:]
+		if !utf8.Valid(line) {
+			line = []byte(string(bytes.Runes(line)))
+		}
+		add(entryUnescaper.Replace(string(line)))
+	}
+}
+
+// historyEntries returns the entries of h, from the oldest to the most
+// recent.
+func historyEntries(h HistoryStore) []string {
+	var entries []string
+	h.Iterate(func(entry string) bool {
+		entries = append(entries, entry)
+		return true
+	})
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
@@ -0,0 +1,272 @@
+package liner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMemoryHistory(t *testing.T) {
+	h := &MemoryHistory{Limit: 3}
+	for _, entry := range []string{"ls", "cd /tmp", "ls -l", "cd"} {
+		h.Append(entry)
+	}
+	if entries := historyEntries(h); !reflect.DeepEqual(entries, []string{"cd /tmp", "ls -l", "cd"}) {
+		t.Fatalf("Unexpected entries %q", entries)
+	}
+	found, err := h.Search("cd")
+	if err != nil || !reflect.DeepEqual(found, []string{"cd", "cd /tmp"}) {
+		t.Fatalf("Unexpected search result %q, %v", found, err)
+	}
+	var newest []string
+	h.Iterate(func(entry string) bool {
+		newest = append(newest, entry)
+		return len(newest) < 2
+	})
+	if !reflect.DeepEqual(newest, []string{"cd", "ls -l"}) {
+		t.Fatalf("Unexpected iteration %q", newest)
+	}
+	h.Delete("cd")
+	if entries := historyEntries(h); !reflect.DeepEqual(entries, []string{"cd /tmp", "ls -l"}) {
+		t.Fatalf("Unexpected entries after delete %q", entries)
+	}
+}
+
+func TestFileHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "liner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history")
+
+	h, err := OpenFileHistory(path)
+	if err != nil {
+		t.Fatal("Unexpected error opening a new history file", err)
+	}
+	for _, entry := range []string{"foo", "bar", "foo"} {
+		if err := h.Append(entry); err != nil {
+			t.Fatal("Unexpected error appending", err)
+		}
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "foo\nbar\nfoo\n" {
+		t.Fatalf("Unexpected file contents %q", data)
+	}
+	if err := h.Delete("foo"); err != nil {
+		t.Fatal("Unexpected error deleting", err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "bar\n" {
+		t.Fatalf("Unexpected file contents after delete %q", data)
+	}
+
+	h.Append("baz")
+	h, err = OpenFileHistory(path)
+	if err != nil {
+		t.Fatal("Unexpected error opening the history file again", err)
+	}
+	if entries := historyEntries(h); !reflect.DeepEqual(entries, []string{"bar", "baz"}) {
+		t.Fatalf("Unexpected entries read back %q", entries)
+	}
+
+	// Entries spanning several rows, with backslashes or longer than a
+	// read buffer are kept whole
+	long := strings.Repeat("x", 10000)
+	for _, entry := range []string{"select\nfrom x;", `a\nb\`, long} {
+		if err := h.Append(entry); err != nil {
+			t.Fatal("Unexpected error appending", err)
+		}
+	}
+	if data, _ := ioutil.ReadFile(path); !strings.HasPrefix(string(data), "bar\nbaz\nselect\\nfrom x;\na\\\\nb\\\\\n") {
+		t.Fatalf("Unexpected file contents %q", data)
+	}
+	h, err = OpenFileHistory(path)
+	if err != nil {
+		t.Fatal("Unexpected error opening the history file again", err)
+	}
+	if entries := historyEntries(h); !reflect.DeepEqual(entries, []string{"bar", "baz", "select\nfrom x;", `a\nb\`, long}) {
+		t.Fatalf("Unexpected entries read back %q", entries)
+	}
+	if h.lines != 5 {
+		t.Fatalf("Expected 5 lines in the file, got %d", h.lines)
+	}
+
+	// A line that isn't valid UTF-8, written by another program, is read
+	// with replacement characters
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("ab\xff\n")
+	f.Close()
+	if err := h.Append("after"); err != nil {
+		t.Fatal("Unexpected error appending after an invalid line", err)
+	}
+	h, err = OpenFileHistory(path)
+	if err != nil {
+		t.Fatal("Unexpected error opening a file with an invalid line", err)
+	}
+	if entries := historyEntries(h); len(entries) != 7 || entries[5] != "ab\ufffd" || entries[6] != "after" {
+		t.Fatalf("Unexpected entries read back %q", entries)
+	}
+}
+
+func TestSetHistoryStore(t *testing.T) {
+	h := &MemoryHistory{}
+	h.Append("foo")
+	var s State
+	s.SetHistoryStore(h)
+	s.AppendHistory("bar")
+	s.AppendHistory("bar")
+	if entries := historyEntries(h); !reflect.DeepEqual(entries, []string{"foo", "bar"}) {
+		t.Fatalf("Unexpected entries %q", entries)
+	}
+	if ph := s.getHistoryByPrefix("f"); !reflect.DeepEqual(ph, []string{"foo"}) {
+		t.Fatalf("Unexpected prefix history %q", ph)
+	}
+}
+
+func TestSharedFileHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "liner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history")
+
+	// Two processes sharing the file
+	h1, err := OpenFileHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := OpenFileHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h1.SetLimit(3)
+	h2.SetLimit(3)
+	h1.Append("one")
+	h2.Append("two")
+	h1.Append("three")
+	if data, _ := ioutil.ReadFile(path); string(data) != "one\ntwo\nthree\n" {
+		t.Fatalf("Unexpected file contents %q", data)
+	}
+	if err := h2.Merge(); err != nil {
+		t.Fatal("Unexpected error merging", err)
+	}
+	if entries := historyEntries(h2); !reflect.DeepEqual(entries, []string{"one", "two", "three"}) {
+		t.Fatalf("Unexpected merged entries %q", entries)
+	}
+
+	// The file is compacted to the limit once it has twice as many
+	// entries, and the other process reads it again
+	h1.Append("four")
+	h1.Append("five")
+	h1.Append("six")
+	if data, _ := ioutil.ReadFile(path); string(data) != "four\nfive\nsix\n" {
+		t.Fatalf("Unexpected file contents after compaction %q", data)
+	}
+	h2.Merge()
+	if entries := historyEntries(h2); !reflect.DeepEqual(entries, []string{"four", "five", "six"}) {
+		t.Fatalf("Unexpected entries after compaction %q", entries)
+	}
+	h2.Append("seven")
+	h1.Merge()
+	if entries := historyEntries(h1); !reflect.DeepEqual(entries, []string{"five", "six", "seven"}) {
+		t.Fatalf("Unexpected entries after appending to the compacted file %q", entries)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "*")); len(names) != 2 {
+		t.Fatalf("Expected only the history and lock files, got %q", names)
+	}
+}
+
+func TestHistoryPolicies(t *testing.T) {
+	var s State
+	s.SetHistoryLimit(3)
+	s.SetHistoryEraseDups(true)
+	s.SetHistoryIgnoreSpace(true)
+	s.SetHistoryIgnorePatterns(regexp.MustCompile(`(?i)password`))
+	s.SetHistoryMinLength(2)
+
+	for _, item := range []string{"ls", " rm -rf /", "x", "set password=hunter2", "cd", "ls", "pwd", "cd"} {
+		s.AppendHistory(item)
+	}
+	var out bytes.Buffer
+	if num, err := s.WriteHistory(&out); num != 3 || err != nil || out.String() != "ls\npwd\ncd\n" {
+		t.Fatalf("Unexpected history written: %d, %v, %q", num, err, out.String())
+	}
+
+	// Entries typed on several rows are written and read back whole
+	var ml State
+	ml.AppendHistory("select\nfrom x;")
+	ml.AppendHistory(`c:\new`)
+	out.Reset()
+	if num, err := ml.WriteHistory(&out); num != 2 || err != nil || out.String() != "select\\nfrom x;\nc:\\\\new\n" {
+		t.Fatalf("Unexpected history written: %d, %v, %q", num, err, out.String())
+	}
+	var ml2 State
+	if num, err := ml2.ReadHistory(&out); num != 2 || err != nil {
+		t.Fatalf("Unexpected ReadHistory result %d, %v", num, err)
+	}
+	if entries := historyEntries(ml2.history()); !reflect.DeepEqual(entries, []string{"select\nfrom x;", `c:\new`}) {
+		t.Fatalf("Unexpected entries read back %q", entries)
+	}
+
+	// The same policies apply to history read and written as is
+	var s2 State
+	s2.SetHistoryEraseDups(true)
+	s2.SetHistoryMinLength(2)
+	num, err := s2.ReadHistory(strings.NewReader("ls\nx\ncd\nls\n"))
+	if num != 4 || err != nil {
+		t.Fatalf("Unexpected ReadHistory result %d, %v", num, err)
+	}
+	if entries := historyEntries(s2.history()); !reflect.DeepEqual(entries, []string{"cd", "ls"}) {
+		t.Fatalf("Unexpected entries read %q", entries)
+	}
+
+	h := &MemoryHistory{}
+	for _, item := range []string{"a", "bb", "a", "cc"} {
+		h.Append(item)
+	}
+	var s3 State
+	s3.SetHistoryStore(h)
+	s3.SetHistoryEraseDups(true)
+	s3.SetHistoryMinLength(2)
+	out.Reset()
+	if num, err := s3.WriteHistory(&out); num != 2 || err != nil || out.String() != "bb\ncc\n" {
+		t.Fatalf("Unexpected history written from a store: %d, %v, %q", num, err, out.String())
+	}
+
+	// A history file is only replaced when an entry erases duplicates
+	dir, err := ioutil.TempDir("", "liner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history")
+	fh, err := OpenFileHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s4 State
+	s4.SetHistoryStore(fh)
+	s4.SetHistoryEraseDups(true)
+	s4.AppendHistory("ls")
+	before, _ := os.Stat(path)
+	s4.AppendHistory("cd")
+	s4.AppendHistory("pwd")
+	if after, _ := os.Stat(path); !os.SameFile(before, after) {
+		t.Fatal("Expected new entries to be appended to the same file")
+	}
+	s4.AppendHistory("ls")
+	if after, _ := os.Stat(path); os.SameFile(before, after) {
+		t.Fatal("Expected the file to be replaced without the duplicate")
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "cd\npwd\nls\n" {
+		t.Fatalf("Unexpected file contents %q", data)
+	}
+}
@@ -0,0 +1,39 @@
+package liner
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// This name is from the Win32 api, so it uses underscores (contrary to what
+// golint suggests)
+const lockfile_exclusive_lock = 0x2
+
+// lockFile takes a lock on the file at path, creating it if needed, for
+// writing if exclusive is set, and returns the function that releases it.
+func lockFile(path string, exclusive bool) (func(), error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	var flags uintptr
+	if exclusive {
+		flags = lockfile_exclusive_lock
+	}
+	var ol syscall.Overlapped
+	ok, _, err := procLockFileEx.Call(f.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if ok == 0 {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+		f.Close()
+	}, nil
+}
@@ -4,9 +4,9 @@ package liner
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"strconv"
@@ -23,9 +23,17 @@ type nexter struct {
 // State represents an open terminal
 type State struct {
 	commonState
+	editorConfig
 	r        *bufio.Reader
+	inFd     int // terminal whose mode is changed, or -1
+	outFd    int // terminal whose size is queried, or -1
+	termSize func() (columns, rows int)
 	origMode termios
+	readFd   int            // file read by r, waited on if deadline is nil, or -1
+	deadline deadlineReader // read by r, if it supports read deadlines
 	next     <-chan nexter
+	want     chan<- struct{}
+	reading  bool // a rune has been requested from the reader goroutine
 	winch    chan os.Signal
 	pending  []rune
 	useCHA   bool
@@ -39,23 +47,66 @@ type State struct {
 // upgrade to a newer release of Go, or ensure that NewLiner is only called
 // once.
 func NewLiner() *State {
-	var s State
+	s := State{inFd: syscall.Stdin, outFd: syscall.Stdout}
 	s.r = bufio.NewReader(os.Stdin)
+	s.watchInput(os.Stdin)
+	s.w = os.Stdout
+	s.init(TerminalSupported())
+	return &s
+}
 
-	s.terminalSupported = TerminalSupported()
-	if s.terminalSupported {
-		if m, err := TerminalMode(); err == nil {
-			s.origMode = *m.(*termios)
+// NewLinerWithConfig initializes a new *State that reads from and renders to
+// the streams described by cfg. If cfg.Terminal is set, it is put into raw
+// mode; call State.Close() to restore it.
+//
+// When cfg.Terminal is nil, line editing is enabled only if cfg.TermSize is
+// set, and the caller is responsible for the input already being raw (as it
+// is on an SSH channel with a pty, for example). Without either, Prompt
+// writes the prompt and reads a whole line from the input without editing.
+func NewLinerWithConfig(cfg Config) *State {
+	s := State{inFd: -1, outFd: -1}
+	var in io.Reader = os.Stdin
+	if cfg.Input != nil {
+		in = cfg.Input
+	}
+	s.r = bufio.NewReader(in)
+	s.watchInput(in)
+	s.w = os.Stdout
+	if cfg.Output != nil {
+		s.w = cfg.Output
+	}
+	s.termSize = cfg.TermSize
+
+	supported := cfg.TermSize != nil
+	if cfg.Terminal != nil {
+		fd := int(cfg.Terminal.Fd())
+		s.inFd, s.outFd = fd, fd
+		supported = TerminalSupported()
+	}
+	s.init(supported)
+	if cfg.Terminal == nil && cfg.TermSize == nil {
+		// There is no terminal to measure, but the output can still
+		// take a prompt
+		s.terminalOutput = true
+	}
+	return &s
+}
+
+func (s *State) init(supported bool) {
+	s.terminalSupported = supported
+	if s.terminalSupported && s.inFd >= 0 {
+		if m, err := terminalMode(s.inFd); err == nil {
+			s.origMode = *m
 		} else {
 			s.terminalSupported = false
 		}
 	}
-	if s.terminalSupported {
+	if s.terminalSupported && s.inFd >= 0 {
 		mode := s.origMode
 		mode.Iflag &^= icrnl | inpck | istrip | ixon
 		mode.Cflag |= cs8
 		mode.Lflag &^= syscall.ECHO | icanon | iexten
-		mode.ApplyMode()
+		mode.applyMode(s.inFd)
 
 		winch := make(chan os.Signal, 1)
 		signal.Notify(winch, syscall.SIGWINCH)
@@ -66,47 +117,193 @@ func NewLiner() *State {
 
 	s.getColumns()
 	s.terminalOutput = s.columns > 0
+}
 
-	return &s
+var (
+	errTimedOut = errors.New("timeout")
+	errResized  = errors.New("resized")
+)
+
+// pollInterval is how often a prompt waiting for input checks whether its
+// context is done or the terminal was resized.
+const pollInterval = 50 * time.Millisecond
+
+// deadlineReader is an input whose reads can be given a deadline, such as a
+// pipe or a network connection.
+type deadlineReader interface {
+	SetReadDeadline(t time.Time) error
 }
 
-var errTimedOut = errors.New("timeout")
+// watchInput sets how s waits for input from in without reading it, so that
+// nothing is read once the prompt is given up: with read deadlines if in
+// supports them, and otherwise by waiting for its file to be readable. Other
+// readers are read by a goroutine instead.
+func (s *State) watchInput(in io.Reader) {
+	s.readFd = -1
+	if d, ok := in.(deadlineReader); ok && d.SetReadDeadline(time.Time{}) == nil {
+		s.deadline = d
+	} else if f, ok := in.(*os.File); ok {
+		s.readFd = int(f.Fd())
+	}
+}
 
+// startPrompt starts the goroutine that reads runes from s.r, if the input
+// can't be waited on otherwise and it isn't already running. The goroutine
+// only reads a rune when one is requested, so that nothing is read on the
+// caller's behalf between prompts.
 func (s *State) startPrompt() {
-	next := make(chan nexter)
+	if s.want != nil || s.deadline != nil || s.readFd >= 0 {
+		return
+	}
+	want := make(chan struct{}, 1)
+	next := make(chan nexter, 1)
 	go func() {
-		for {
+		for range want {
 			var n nexter
 			n.r, _, n.err = s.r.ReadRune()
 			next <- n
-			// Shut down nexter loop when an end condition has been reached
-			if n.err != nil || n.r == '\n' || n.r == '\r' || n.r == ctrlD {
-				close(next)
-				return
-			}
 		}
 	}()
+	s.want = want
 	s.next = next
 }
 
-func (s *State) nextPending(timeout <-chan time.Time) (rune, error) {
-	select {
-	case thing, ok := <-s.next:
-		if !ok {
-			return 0, errors.New("liner: internal error")
+// request asks the reader goroutine for a rune, unless one has already been
+// asked for and not yet received. A rune that is requested but not received
+// because the prompt's context was done is received by the next prompt.
+func (s *State) request() {
+	if s.want != nil && !s.reading {
+		s.want <- struct{}{}
+		s.reading = true
+	}
+}
+
+// readRune reads the next rune of input. It returns errTimedOut if deadline
+// is not zero and passes first, the error of the prompt's context if it is
+// done first, and errResized if resized is true and the terminal is resized
+// first. In those cases nothing has been read, unless the input is read by
+// the reader goroutine.
+func (s *State) readRune(deadline time.Time, resized bool) (rune, error) {
+	var winch <-chan os.Signal
+	if resized {
+		winch = s.winch
+	}
+	if s.next != nil {
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			timer := time.NewTimer(deadline.Sub(time.Now()))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		s.request()
+		select {
+		case thing, ok := <-s.next:
+			if !ok {
+				return 0, errors.New("liner: internal error")
+			}
+			s.reading = false
+			return thing.r, thing.err
+		case <-timeout:
+			return 0, errTimedOut
+		case <-winch:
+			return 0, errResized
+		case <-s.ctxDone():
+			return 0, s.ctx.Err()
+		}
+	}
+
+	for {
+		if s.r.Buffered() > 0 || (deadline.IsZero() && winch == nil && s.ctxDone() == nil) {
+			r, _, err := s.r.ReadRune()
+			return r, err
+		}
+		select {
+		case <-winch:
+			return 0, errResized
+		case <-s.ctxDone():
+			return 0, s.ctx.Err()
+		default:
+		}
+		wait := pollInterval
+		if !deadline.IsZero() {
+			left := deadline.Sub(time.Now())
+			if left <= 0 {
+				return 0, errTimedOut
+			}
+			if left < wait {
+				wait = left
+			}
 		}
-		if thing.err != nil {
-			return 0, thing.err
+
+		if s.deadline != nil {
+			s.deadline.SetReadDeadline(time.Now().Add(wait))
+			r, _, err := s.r.ReadRune()
+			s.deadline.SetReadDeadline(time.Time{})
+			if t, ok := err.(interface {
+				Timeout() bool
+			}); !ok || !t.Timeout() {
+				return r, err
+			}
+		} else {
+			ready, err := inputReady(s.readFd, wait)
+			if err != nil {
+				return 0, err
+			}
+			if ready {
+				r, _, err := s.r.ReadRune()
+				return r, err
+			}
 		}
-		s.pending = append(s.pending, thing.r)
-		return thing.r, nil
-	case <-timeout:
+	}
+}
+
+// inputReady waits up to timeout for fd to be readable, and reports whether
+// it is.
+func inputReady(fd int, timeout time.Duration) (bool, error) {
+	var set syscall.FdSet
+	setFd(&set, fd)
+	tv := syscall.NsecToTimeval(int64(timeout))
+	err := selectRead(fd+1, &set, &tv)
+	if err == syscall.EINTR {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return fdIsSet(&set, fd), nil
+}
+
+func (s *State) nextPending(deadline time.Time) (rune, error) {
+	r, err := s.readRune(deadline, false)
+	switch {
+	case err == errTimedOut:
 		rv := s.pending[0]
 		s.pending = s.pending[1:]
 		return rv, errTimedOut
+	case err != nil && s.ctx != nil && err == s.ctx.Err():
+		s.pending = s.pending[:0]
+		return 0, err
+	case err != nil:
+		return 0, err
 	}
-	// not reached
-	return 0, nil
+	s.pending = append(s.pending, r)
+	return r, nil
+}
+
+// readPaste reads the text pasted after ESC[200~, up to ESC[201~.
+func (s *State) readPaste() (interface{}, error) {
+	end := []rune("\x1b[201~")
+	for {
+		if n := len(s.pending) - len(end); n >= 0 && string(s.pending[n:]) == string(end) {
+			break
+		}
+		if _, err := s.nextPending(time.Time{}); err != nil {
+			return nil, err
+		}
+	}
+	text := string(s.pending[:len(s.pending)-len(end)])
+	s.pending = s.pending[:0]
+	return pastedText(text), nil
 }
 
 func (s *State) readNext() (interface{}, error) {
@@ -115,20 +312,13 @@ func (s *State) readNext() (interface{}, error) {
 		s.pending = s.pending[1:]
 		return rv, nil
 	}
-	var r rune
-	select {
-	case thing, ok := <-s.next:
-		if !ok {
-			return 0, errors.New("liner: internal error")
-		}
-		if thing.err != nil {
-			return nil, thing.err
-		}
-		r = thing.r
-	case <-s.winch:
-		s.getColumns()
+	r, err := s.readRune(time.Time{}, true)
+	if err == errResized {
 		return winch, nil
 	}
+	if err != nil {
+		return nil, err
+	}
 	if r != esc {
 		return r, nil
 	}
@@ -136,7 +326,7 @@ func (s *State) readNext() (interface{}, error) {
 
 	// Wait at most 50 ms for the rest of the escape sequence
 	// If nothing else arrives, it was an actual press of the esc key
-	timeout := time.After(50 * time.Millisecond)
+	timeout := time.Now().Add(50 * time.Millisecond)
 	flag, err := s.nextPending(timeout)
 	if err != nil {
 		if err == errTimedOut {
@@ -238,6 +428,8 @@ func (s *State) readNext() (interface{}, error) {
 					s.pending = s.pending[:0] // escape code complete
 					x, _ := strconv.ParseInt(string(num), 10, 32)
 					switch x {
+					case 200:
+						return s.readPaste()
 					case 2:
 						return insert, nil
 					case 3:
@@ -320,20 +512,37 @@ func (s *State) readNext() (interface{}, error) {
 	return r, nil
 }
 
+// promptUnsupported writes p and reads a line without editing it, until the
+// context of the prompt, if any, is done.
 func (s *State) promptUnsupported(p string) (string, error) {
-	fmt.Print(p)
-	linebuf, _, err := s.r.ReadLine()
-	if err != nil {
-		return "", err
+	fmt.Fprint(s.w, p)
+	s.startPrompt()
+	var line []rune
+	for {
+		r, err := s.readRune(time.Time{}, false)
+		if err == io.EOF && len(line) > 0 {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if r == '\n' {
+			break
+		}
+		line = append(line, r)
 	}
-	return string(bytes.TrimSpace(linebuf)), nil
+	return strings.TrimSpace(string(line)), nil
 }
 
 // Close returns the terminal to its previous mode
 func (s *State) Close() error {
+	if s.want != nil {
+		close(s.want)
+		s.want = nil
+	}
 	stopSignal(s.winch)
-	if s.terminalSupported {
-		s.origMode.ApplyMode()
+	if s.terminalSupported && s.inFd >= 0 {
+		s.origMode.applyMode(s.inFd)
 	}
 	return nil
 }
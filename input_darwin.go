@@ -36,3 +36,7 @@ type termios struct {
 	Ispeed uintptr
 	Ospeed uintptr
 }
+
+func selectRead(n int, set *syscall.FdSet, timeout *syscall.Timeval) error {
+	return syscall.Select(n, set, nil, nil, timeout)
+}
@@ -23,3 +23,8 @@ const (
 type termios struct {
 	syscall.Termios
 }
+
+func selectRead(n int, set *syscall.FdSet, timeout *syscall.Timeval) error {
+	_, err := syscall.Select(n, set, nil, nil, timeout)
+	return err
+}
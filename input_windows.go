@@ -1,7 +1,12 @@
 package liner
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
+	"os"
 	"syscall"
 	"unsafe"
 )
@@ -16,6 +21,7 @@ var (
 	procSetConsoleCursorPosition   = kernel32.NewProc("SetConsoleCursorPosition")
 	procGetConsoleScreenBufferInfo = kernel32.NewProc("GetConsoleScreenBufferInfo")
 	procFillConsoleOutputCharacter = kernel32.NewProc("FillConsoleOutputCharacterW")
+	procWaitForSingleObject        = kernel32.NewProc("WaitForSingleObject")
 )
 
 // These names are from the Win32 api, so they use underscores (contrary to
@@ -25,6 +31,7 @@ const (
 	std_output_handle    = uint32(-11 & 0xFFFFFFFF)
 	std_error_handle     = uint32(-12 & 0xFFFFFFFF)
 	invalid_handle_value = ^uintptr(0)
+	wait_timeout         = 0x102
 )
 
 type inputMode uint32
@@ -32,11 +39,14 @@ type inputMode uint32
 // State represents an open terminal
 type State struct {
 	commonState
+	editorConfig
+	r        *bufio.Reader // non-nil when not reading from the console
 	handle   syscall.Handle
 	hOut     syscall.Handle
 	origMode inputMode
 	key      interface{}
 	repeat   uint16
+	altKey   interface{} // key typed with Alt, to return after Esc
 }
 
 const (
@@ -57,6 +67,7 @@ func NewLiner() *State {
 	s.handle = syscall.Handle(hIn)
 	hOut, _, _ := procGetStdHandle.Call(uintptr(std_output_handle))
 	s.hOut = syscall.Handle(hOut)
+	s.w = os.Stdout
 
 	s.terminalSupported = true
 	if m, err := TerminalMode(); err == nil {
@@ -76,6 +87,29 @@ func NewLiner() *State {
 	return &s
 }
 
+// NewLinerWithConfig initializes a new *State that reads from and writes to
+// the streams described by cfg. Line editing on Windows requires the
+// console, so unless Input and Output are both nil (in which case this is
+// equivalent to NewLiner), the returned State reads whole lines from Input
+// without editing. Terminal and TermSize are ignored.
+func NewLinerWithConfig(cfg Config) *State {
+	if cfg.Input == nil && cfg.Output == nil {
+		return NewLiner()
+	}
+	var s State
+	var in io.Reader = os.Stdin
+	if cfg.Input != nil {
+		in = cfg.Input
+	}
+	s.r = bufio.NewReader(in)
+	s.w = os.Stdout
+	if cfg.Output != nil {
+		s.w = cfg.Output
+	}
+	s.terminalOutput = true
+	return &s
+}
+
 // These names are from the Win32 api, so they use underscores (contrary to
 // what golint suggests)
 const (
@@ -145,6 +179,11 @@ func (s *State) readNext() (interface{}, error) {
 		s.repeat--
 		return s.key, nil
 	}
+	if s.altKey != nil {
+		key := s.altKey
+		s.altKey = nil
+		return key, nil
+	}
 
 	var input input_record
 	pbuf := uintptr(unsafe.Pointer(&input))
@@ -152,6 +191,9 @@ func (s *State) readNext() (interface{}, error) {
 	prv := uintptr(unsafe.Pointer(&rv))
 
 	for {
+		if err := s.waitInput(); err != nil {
+			return nil, err
+		}
 		ok, _, err := procReadConsoleInput.Call(uintptr(s.handle), pbuf, 1, prv)
 
 		if ok == 0 {
@@ -159,8 +201,6 @@ func (s *State) readNext() (interface{}, error) {
 		}
 
 		if input.eventType == window_buffer_size_event {
-			xy := (*coord)(unsafe.Pointer(&input.blob[0]))
-			s.columns = int(xy.x)
 			return winch, nil
 		}
 		if input.eventType != key_event {
@@ -176,6 +216,11 @@ func (s *State) readNext() (interface{}, error) {
 		} else if ke.VirtualKeyCode == yKey && (ke.ControlKeyState&modKeys == leftAltPressed ||
 			ke.ControlKeyState&modKeys == rightAltPressed) {
 			s.key = altY
+		} else if mods := ke.ControlKeyState & modKeys &^ shiftPressed; ke.Char > 0 &&
+			(mods == leftAltPressed || mods == rightAltPressed) {
+			// Like a terminal, send Esc before the key typed with Alt
+			s.altKey = rune(ke.Char)
+			return rune(esc), nil
 		} else if ke.Char > 0 {
 			s.key = rune(ke.Char)
 		} else {
@@ -250,13 +295,44 @@ func (s *State) readNext() (interface{}, error) {
 	return unknown, nil
 }
 
+// waitInput waits until console input is available, or the context of the
+// prompt in progress is done.
+func (s *State) waitInput() error {
+	done := s.ctxDone()
+	if done == nil {
+		return nil
+	}
+	for {
+		select {
+		case <-done:
+			return s.ctx.Err()
+		default:
+		}
+		// Check the context again every 50 ms
+		rv, _, _ := procWaitForSingleObject.Call(uintptr(s.handle), 50)
+		if rv != wait_timeout {
+			return nil
+		}
+	}
+}
+
 func (s *State) promptUnsupported(p string) (string, error) {
-	return "", errors.New("liner: internal error: always supported on Windows")
+	if s.r == nil {
+		return "", errors.New("liner: internal error: always supported on Windows")
+	}
+	fmt.Fprint(s.w, p)
+	linebuf, _, err := s.r.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(linebuf)), nil
 }
 
 // Close returns the terminal to its previous mode
 func (s *State) Close() error {
-	s.origMode.ApplyMode()
+	if s.r == nil {
+		s.origMode.ApplyMode()
+	}
 	return nil
 }
 
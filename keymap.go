@@ -0,0 +1,276 @@
+// +build windows linux darwin openbsd freebsd netbsd
+
+package liner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// KeyHandler is called when the key sequence it is bound to is typed. It may
+// inspect and change the line through e, and the line is redrawn after it
+// returns.
+type KeyHandler func(e *Editor)
+
+type editCommand func(e *Editor) error
+
+// editCommands are the commands a key sequence can be bound to by name. The
+// names follow GNU Readline where there is an equivalent.
+var editCommands = map[string]editCommand{
+	"accept-line":            (*Editor).acceptLine,
+	"backward-char":          (*Editor).backwardChar,
+	"backward-delete-char":   (*Editor).backwardDeleteChar,
+	"backward-word":          (*Editor).backwardWord,
+	"beep":                   (*Editor).ding,
+	"beginning-of-line":      (*Editor).beginningOfLine,
+	"clear-screen":           (*Editor).clearScreen,
+	"complete":               (*Editor).complete,
+	"delete-char":            (*Editor).deleteChar,
+	"delete-char-or-eof":     (*Editor).deleteCharOrEOF,
+	"end-of-line":            (*Editor).endOfLine,
+	"forward-char":           (*Editor).forwardChar,
+	"forward-word":           (*Editor).forwardWord,
+	"ignore":                 (*Editor).ignore,
+	"interrupt":              (*Editor).interrupt,
+	"kill-line":              (*Editor).killLine,
+	"next-history":           (*Editor).nextHistory,
+	"previous-history":       (*Editor).previousHistory,
+	"redo":                   (*Editor).redo,
+	"redraw-current-line":    (*Editor).redraw,
+	"reverse-search-history": (*Editor).reverseSearchHistory,
+	"transpose-chars":        (*Editor).transposeChars,
+	"undo":                   (*Editor).undo,
+	"unix-line-discard":      (*Editor).unixLineDiscard,
+	"unix-word-rubout":       (*Editor).unixWordRubout,
+	"vi-movement-mode":       (*Editor).viMovementMode,
+	"yank":                   (*Editor).yank,
+}
+
+// A Keymap maps key sequences to editing commands. The zero value is an
+// empty Keymap, in which every printable character inserts itself and every
+// other key beeps or does nothing.
+type Keymap struct {
+	root binding
+}
+
+type binding struct {
+	command  editCommand
+	children map[interface{}]*binding
+}
+
+var defaultKeymap = DefaultKeymap()
+
+// DefaultKeymap returns a new Keymap with the bindings listed in the README.
+// Changing it does not change the bindings of other Keymaps.
+func DefaultKeymap() *Keymap {
+	km := new(Keymap)
+	for _, b := range []struct{ keys, command string }{
+		{"Enter", "accept-line"},
+		{"Ctrl-J", "accept-line"},
+		{"Ctrl-A", "beginning-of-line"},
+		{"Home", "beginning-of-line"},
+		{"Ctrl-E", "end-of-line"},
+		{"End", "end-of-line"},
+		{"Ctrl-B", "backward-char"},
+		{"Left", "backward-char"},
+		{"Ctrl-F", "forward-char"},
+		{"Right", "forward-char"},
+		{"Ctrl-Left", "backward-word"},
+		{"Ctrl-Right", "forward-word"},
+		{"Alt-b", "backward-word"},
+		{"Alt-f", "forward-word"},
+		{"Ctrl-D", "delete-char-or-eof"},
+		{"Delete", "delete-char"},
+		{"Ctrl-H", "backward-delete-char"},
+		{"Backspace", "backward-delete-char"},
+		{"Ctrl-K", "kill-line"},
+		{"Ctrl-U", "unix-line-discard"},
+		{"Ctrl-W", "unix-word-rubout"},
+		{"Ctrl-P", "previous-history"},
+		{"Up", "previous-history"},
+		{"Ctrl-N", "next-history"},
+		{"Down", "next-history"},
+		{"Ctrl-T", "transpose-chars"},
+		{"Ctrl-L", "clear-screen"},
+		{"Ctrl-C", "interrupt"},
+		{"Tab", "complete"},
+		{"Ctrl-Y", "yank"},
+		{"Ctrl-R", "reverse-search-history"},
+		{"Ctrl-_", "undo"},
+		{"Ctrl-X Ctrl-U", "undo"},
+		{"Alt-Ctrl-_", "redo"},
+		{"Esc", "ignore"},
+	} {
+		if err := km.Bind(b.keys, b.command); err != nil {
+			panic(err)
+		}
+	}
+	return km
+}
+
+// Bind binds the key sequence keys to the named editing command, replacing
+// what keys was bound to. The command names are listed in the README.
+//
+// A key sequence is a list of keys separated by spaces, such as "Ctrl-X
+// Ctrl-E". A key is a single character, Ctrl- followed by a letter or one of
+// @[\]^_, Alt- followed by a key (which is the same as Esc followed by the
+// key), or one of Tab, Shift-Tab, Enter, Esc, Backspace, Space, Up, Down,
+// Left, Right, Ctrl-Left, Ctrl-Right, Home, End, Insert, Delete, PageUp,
+// PageDown and F1 to F12.
+func (km *Keymap) Bind(keys string, command string) error {
+	c, ok := editCommands[command]
+	if !ok {
+		return fmt.Errorf("liner: unknown editing command %q", command)
+	}
+	return km.bind(keys, c)
+}
+
+// BindFunc binds the key sequence keys, written as for Bind, to h.
+func (km *Keymap) BindFunc(keys string, h KeyHandler) error {
+	if h == nil {
+		return errors.New("liner: nil KeyHandler")
+	}
+	return km.bind(keys, func(e *Editor) error {
+		e.s.unlocked(func() { h(e) })
+		e.refresh()
+		return nil
+	})
+}
+
+// Unbind removes the binding of the key sequence keys, written as for Bind.
+// Longer sequences that start with keys stay bound.
+func (km *Keymap) Unbind(keys string) error {
+	seq, err := parseKeys(keys)
+	if err != nil {
+		return err
+	}
+	path := []*binding{&km.root}
+	for _, k := range seq {
+		b := path[len(path)-1].children[k]
+		if b == nil {
+			return nil
+		}
+		path = append(path, b)
+	}
+	path[len(path)-1].command = nil
+	for i := len(path) - 1; i > 0; i-- {
+		b := path[i]
+		if b.command != nil || len(b.children) > 0 {
+			break
+		}
+		delete(path[i-1].children, seq[i-1])
+	}
+	return nil
+}
+
+func (km *Keymap) bind(keys string, c editCommand) error {
+	seq, err := parseKeys(keys)
+	if err != nil {
+		return err
+	}
+	b := &km.root
+	for _, k := range seq {
+		if b.children == nil {
+			b.children = make(map[interface{}]*binding)
+		}
+		child := b.children[k]
+		if child == nil {
+			child = new(binding)
+			b.children[k] = child
+		}
+		b = child
+	}
+	b.command = c
+	return nil
+}
+
+// SetKeymap makes Prompt use the bindings of km. A nil km restores the
+// default bindings. Changes made to km after calling SetKeymap take effect
+// at the next Prompt.
+func (s *State) SetKeymap(km *Keymap) {
+	s.keymap = km
+}
+
+var namedKeys = map[string]interface{}{
+	"tab":        rune(tab),
+	"shift-tab":  shiftTab,
+	"enter":      rune(cr),
+	"esc":        rune(esc),
+	"backspace":  rune(bs),
+	"space":      ' ',
+	"up":         up,
+	"down":       down,
+	"left":       left,
+	"right":      right,
+	"ctrl-left":  wordLeft,
+	"ctrl-right": wordRight,
+	"home":       home,
+	"end":        end,
+	"insert":     insert,
+	"delete":     del,
+	"pageup":     pageUp,
+	"pagedown":   pageDown,
+	"f1":         f1,
+	"f2":         f2,
+	"f3":         f3,
+	"f4":         f4,
+	"f5":         f5,
+	"f6":         f6,
+	"f7":         f7,
+	"f8":         f8,
+	"f9":         f9,
+	"f10":        f10,
+	"f11":        f11,
+	"f12":        f12,
+}
+
+// parseKeys converts a key sequence written as for Bind into the keys
+// readNext returns when it is typed.
+func parseKeys(keys string) ([]interface{}, error) {
+	var seq []interface{}
+	for _, name := range strings.Fields(keys) {
+		k, err := parseKey(name)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, k...)
+	}
+	if len(seq) == 0 {
+		return nil, errors.New("liner: empty key sequence")
+	}
+	return seq, nil
+}
+
+func parseKey(name string) ([]interface{}, error) {
+	if utf8.RuneCountInString(name) == 1 {
+		r, _ := utf8.DecodeRuneInString(name)
+		return []interface{}{r}, nil
+	}
+	if k, ok := namedKeys[strings.ToLower(name)]; ok {
+		return []interface{}{k}, nil
+	}
+	switch lower := strings.ToLower(name); {
+	case strings.HasPrefix(lower, "ctrl-") && len(name) == len("ctrl-")+1:
+		c := lower[len(lower)-1]
+		if c >= 'a' && c <= 'z' {
+			return []interface{}{rune(c - 'a' + 1)}, nil
+		}
+		if c >= '@' && c <= '_' {
+			return []interface{}{rune(c - '@')}, nil
+		}
+	case strings.HasPrefix(lower, "alt-") && len(name) > len("alt-"):
+		rest := name[len("alt-"):]
+		if rest == "y" {
+			// readNext reports Esc y as a key of its own
+			return []interface{}{altY}, nil
+		}
+		k, err := parseKey(rest)
+		if err != nil {
+			return nil, err
+		}
+		return append([]interface{}{rune(esc)}, k...), nil
+	}
+	return nil, fmt.Errorf("liner: unknown key %q", name)
+}
@@ -0,0 +1,63 @@
+// +build windows linux darwin openbsd freebsd netbsd
+
+package liner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		keys     string
+		expected []interface{}
+	}{
+		{"a", []interface{}{'a'}},
+		{"Ctrl-A", []interface{}{rune(ctrlA)}},
+		{"ctrl-x ctrl-u", []interface{}{rune(ctrlX), rune(ctrlU)}},
+		{"Ctrl-_", []interface{}{rune(31)}},
+		{"Alt-f", []interface{}{rune(esc), 'f'}},
+		{"Alt-y", []interface{}{altY}},
+		{"Alt-Ctrl-H", []interface{}{rune(esc), rune(ctrlH)}},
+		{"F12", []interface{}{f12}},
+		{"Shift-Tab", []interface{}{shiftTab}},
+		{"Enter Space", []interface{}{rune(cr), ' '}},
+	}
+	for _, test := range tests {
+		seq, err := parseKeys(test.keys)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %v", test.keys, err)
+			continue
+		}
+		if !reflect.DeepEqual(seq, test.expected) {
+			t.Errorf("Expected %v for %q, got %v", test.expected, test.keys, seq)
+		}
+	}
+
+	for _, keys := range []string{"", "Ctrl-1", "Hyper-A", "F13"} {
+		if _, err := parseKeys(keys); err == nil {
+			t.Errorf("Expected an error parsing %q", keys)
+		}
+	}
+}
+
+func TestUnbind(t *testing.T) {
+	var km Keymap
+	if err := km.Bind("Ctrl-X Ctrl-U", "yank"); err != nil {
+		t.Fatal(err)
+	}
+	if err := km.Bind("Ctrl-X", "beep"); err != nil {
+		t.Fatal(err)
+	}
+	if err := km.Bind("Ctrl-X", "no-such-command"); err == nil {
+		t.Fatal("Expected an error binding an unknown command")
+	}
+	km.Unbind("Ctrl-X Ctrl-U")
+	if b := km.root.children[rune(ctrlX)]; b == nil || b.command == nil || len(b.children) != 0 {
+		t.Fatal("Expected Ctrl-X to stay bound without children")
+	}
+	km.Unbind("Ctrl-X")
+	if len(km.root.children) != 0 {
+		t.Fatal("Expected an empty keymap")
+	}
+}
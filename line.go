@@ -4,16 +4,21 @@ package liner
 
 import (
 	"container/ring"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"regexp"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
 type action int
 
+// pastedText is text pasted into a terminal in bracketed paste mode
+type pastedText string
+
 const (
 	left action = iota
 	right
@@ -80,80 +85,413 @@ const (
 	beep = "\a"
 )
 
-var (
-	colorExpr *regexp.Regexp
-)
+var colorExpr = regexp.MustCompile("\x1b[^m]*m")
 
 func (s *State) refresh(prompt string, buf string, pos int) error {
+	s.shownPrompt, s.shownBuf, s.shownPos = prompt, buf, pos
+	s.shownSuggestion = s.suggestion
+	buf = showControls(buf)
+	suggestion := s.suggestion
+	s.suggestion = showControls(suggestion)
+	defer func() { s.suggestion = suggestion }()
+	var err error
+	if s.multiRow() {
+		err = s.refreshMultiLine(prompt, buf, pos)
+	} else {
+		err = s.refreshSingleLine(prompt, buf, pos)
+	}
+	s.refreshBelow()
+	return err
+}
+
+// showControls returns text with its control characters other than newlines
+// replaced by their symbols from the Control Pictures block, such as ␛
+// for Escape, or by U+FFFD. The terminal would otherwise move the cursor or
+// interpret escape sequences. Each is replaced by a single rune one cell
+// wide, so that positions in runes are unchanged.
+func showControls(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n':
+			return r
+		case r < 0x20:
+			return 0x2400 + r
+		case r == 0x7f:
+			return 0x2421
+		case unicode.IsControl(r):
+			return utf8.RuneError
+		}
+		return r
+	}, text)
+}
+
+// refreshBelow draws s.below, the message and the toolbar on the rows under the buffer,
+// erasing what was drawn there before, and puts the cursor back where refresh
+// left it.
+func (s *State) refreshBelow() {
+	rows := s.below[:len(s.below):len(s.below)]
+	if s.message != "" {
+		rows = append(rows, s.message)
+	}
+	rows = append(rows, s.toolbarRows()...)
+	s.drawBelow(rows)
+}
+
+// drawBelow draws rows under the buffer, erasing what was drawn there before,
+// and puts the cursor back where refresh left it. With no rows, it only erases
+// them.
+func (s *State) drawBelow(rows []string) {
+	if len(rows) == 0 && s.belowRows == 0 {
+		return
+	}
+	down := 1
+	if s.multiRow() {
+		down = s.nextRow - s.cursorRow
+	}
+	for i := 0; i < down; i++ {
+		fmt.Fprintln(s.w)
+	}
+	s.eraseBelow()
+	for i, row := range rows {
+		if i > 0 {
+			fmt.Fprintln(s.w)
+		}
+		fmt.Fprint(s.w, fitRow(row, s.columns-1))
+	}
+	up := down
+	if len(rows) > 0 {
+		up += len(rows) - 1
+	}
+	if up > 0 {
+		s.cursorUp(up)
+	}
+	s.cursorPos(s.cursorCol)
+	s.belowRows = len(rows)
+}
+
+func (s *State) refreshSingleLine(prompt string, buf string, pos int) error {
+	// A single row can't show line breaks
+	buf = strings.Replace(buf, "\n", " ", -1)
+
 	s.cursorPos(0)
-	_, err := fmt.Print(prompt)
+	_, err := fmt.Fprint(s.w, prompt)
 	if err != nil {
 		return err
 	}
 
-	pLen := utf8.RuneCountInString(stripAnsiColorSequences(prompt))
-	bLen := utf8.RuneCountInString(buf)
+	pLen := stringWidth(stripAnsiColorSequences(prompt))
+	line := []rune(buf)
+	bLen := runesWidth(line)
+	styled := s.highlight(buf, pos)
 	if pLen+bLen < s.columns {
-		_, err = fmt.Print(buf)
+		_, err = fmt.Fprint(s.w, styled)
+		if styled != buf {
+			fmt.Fprint(s.w, "\x1b[0m")
+		}
+		space := s.columns - 1 - pLen - bLen
+		rpCol := s.rightPromptCol(pLen + bLen)
+		if rpCol >= 0 {
+			space = rpCol - 1 - pLen - bLen
+		}
+		if suggestion := truncateWidth(s.suggestion, space); suggestion != "" {
+			fmt.Fprint(s.w, "\x1b[2m"+suggestion+"\x1b[0m")
+		}
 		s.eraseLine()
-		s.cursorPos(pLen + pos)
+		if rpCol >= 0 {
+			s.cursorPos(rpCol)
+			fmt.Fprint(s.w, s.rightPrompt)
+		}
+		s.cursorCol = pLen + runesWidth(line[:pos])
+		s.cursorPos(s.cursorCol)
 	} else {
 		// Find space available
 		space := s.columns - pLen
 		space-- // space for cursor
-		start := pos - space/2
-		end := start + space
-		if end > bLen {
-			end = bLen
-			start = end - space
+		start, end := scrollWindow(line, pos, space)
+
+		// Output
+		x := pLen
+		if start > 0 {
+			fmt.Fprint(s.w, "{")
+			x++
+		}
+		if styled != buf {
+			fmt.Fprint(s.w, sliceStyled(styled, start, end)+"\x1b[0m")
+		} else {
+			fmt.Fprint(s.w, string(line[start:end]))
 		}
-		if start < 0 {
-			start = 0
-			end = space
+		if end < len(line) {
+			fmt.Fprint(s.w, "}")
 		}
-		pos -= start
 
-		// Leave space for markers
-		if start > 0 {
+		// Set cursor position
+		s.eraseLine()
+		s.cursorCol = x + runesWidth(line[start:pos])
+		s.cursorPos(s.cursorCol)
+	}
+	return err
+}
+
+// scrollWindow returns the part of line to display in space cells, with pos
+// roughly in the middle. If the window does not start at the beginning of
+// line, or end at its end, a cell is left free for a scroll marker.
+func scrollWindow(line []rune, pos int, space int) (start, end int) {
+	width := 0
+	start = pos
+	for start > 0 && width+runeWidth(line[start-1]) <= space/2 {
+		start--
+		width += runeWidth(line[start])
+	}
+	end = pos
+	for end < len(line) && width+runeWidth(line[end]) <= space {
+		width += runeWidth(line[end])
+		end++
+	}
+	for end == len(line) && start > 0 && width+runeWidth(line[start-1]) <= space {
+		start--
+		width += runeWidth(line[start])
+	}
+
+	// Leave space for markers
+	if start > 0 {
+		freed := 0
+		for start < pos && (freed < 1 || runeWidth(line[start]) == 0) {
+			freed += runeWidth(line[start])
 			start++
 		}
-		if end < bLen {
+	}
+	if end < len(line) {
+		freed := 0
+		for end > pos && freed < 1 {
 			end--
+			freed += runeWidth(line[end])
 		}
-		line := []rune(buf)
-		line = line[start:end]
+	}
+	return start, end
+}
 
-		// Output
-		if start > 0 {
-			fmt.Print("{")
+// rightPromptCol returns the column at which the right prompt is drawn on a
+// row whose first used cells are taken, or -1 if it is hidden.
+func (s *State) rightPromptCol(used int) int {
+	if s.rightPrompt == "" {
+		return -1
+	}
+	col := s.columns - 1 - stringWidth(stripAnsiColorSequences(s.rightPrompt))
+	if used >= col {
+		return -1
+	}
+	return col
+}
+
+// advance returns the row and column of the cell after text, when text is
+// drawn from row and col of a terminal that is columns wide. Like the
+// terminal, it moves wide characters that don't fit at the end of a row to
+// the start of the next, and leaves the column at columns after a row has
+// been completely filled, until more is drawn. Newlines move to column
+// margin of the next row, past the continuation prompt.
+func advance(text []rune, columns, margin, row, col int) (int, int) {
+	for _, r := range text {
+		if r == '\n' {
+			row++
+			col = margin
+			continue
 		}
-		fmt.Print(string(line))
-		if end < bLen {
-			fmt.Print("}")
+		w := runeWidth(r)
+		if col+w > columns {
+			row++
+			col = 0
 		}
+		col += w
+	}
+	return row, col
+}
 
-		// Set cursor position
-		s.eraseLine()
-		s.cursorPos(pLen + pos)
+func (s *State) refreshMultiLine(prompt string, buf string, pos int) error {
+	if s.columns != s.renderColumns && s.columns > 0 {
+		// The terminal has reflowed the rows drawn by the last refresh
+		s.cursorRow = s.cursorCells / s.columns
+	}
+	if s.cursorRow > 0 {
+		s.cursorUp(s.cursorRow)
+	}
+	s.cursorPos(0)
+	s.eraseBelow()
+	_, err := fmt.Fprint(s.w, prompt)
+	if err != nil {
+		return err
+	}
+
+	columns := s.columns
+	if columns <= 0 {
+		columns = 1
+	}
+	margin := stringWidth(stripAnsiColorSequences(s.continuation))
+	row, col := advance([]rune(stripAnsiColorSequences(prompt)), columns, margin, 0, 0)
+
+	first := buf + s.suggestion
+	if i := strings.IndexRune(first, '\n'); i >= 0 {
+		first = first[:i]
+	}
+	if r, c := advance([]rune(first), columns, margin, row, col); r == 0 {
+		if rpCol := s.rightPromptCol(c); rpCol >= 0 {
+			s.cursorPos(rpCol)
+			fmt.Fprint(s.w, s.rightPrompt)
+			s.cursorPos(col)
+		}
+	}
+
+	styled := s.highlight(buf, pos)
+	if styled != buf {
+		styled += "\x1b[0m"
+	}
+	if s.suggestion != "" {
+		styled += "\x1b[2m" + s.suggestion + "\x1b[0m"
+	}
+	// Print the input a row at a time, starting the rows after the first
+	// with the continuation prompt
+	plain := strings.Split(buf+s.suggestion, "\n")
+	c := col
+	for i, part := range strings.Split(styled, "\n") {
+		if i > 0 {
+			if c == columns {
+				s.forceWrap()
+			} else {
+				fmt.Fprint(s.w, "\r\n")
+			}
+			fmt.Fprint(s.w, s.continuation)
+			c = margin
+		}
+		fmt.Fprint(s.w, part)
+		_, c = advance([]rune(plain[i]), columns, margin, 0, c)
+	}
+
+	line := []rune(buf)
+	cursorRow, cursorCol := advance(line[:pos], columns, margin, row, col)
+	rest := append(line[pos:len(line):len(line)], []rune(s.suggestion)...)
+	endRow, endCol := advance(rest, columns, margin, cursorRow, cursorCol)
+	if cursorCol == columns {
+		cursorRow, cursorCol = cursorRow+1, 0
+	}
+	s.nextRow = endRow + 1
+	if endCol == columns {
+		s.forceWrap()
+		endRow++
+		s.nextRow = endRow
+	}
+	if endRow > cursorRow {
+		s.cursorUp(endRow - cursorRow)
 	}
+	s.cursorPos(cursorCol)
+
+	s.cursorCol = cursorCol
+	s.cursorRow = cursorRow
+	s.cursorCells = cursorRow*columns + cursorCol
+	s.renderColumns = s.columns
 	return err
 }
 
+// printPrompt starts a new prompt
+func (s *State) printPrompt(p string) {
+	s.shownPrompt, s.shownBuf, s.shownPos, s.shownSuggestion = p, "", 0, ""
+	if s.multiRow() || s.rightPrompt != "" || len(s.toolbarRows()) > 0 {
+		s.cursorRow = 0
+		s.cursorCells = 0
+		s.renderColumns = s.columns
+		s.refresh(p, "", 0)
+		return
+	}
+	fmt.Fprint(s.w, p)
+}
+
+// printAbove prints text on rows of its own in place of the prompt in
+// progress, if any, and then draws the prompt again below it as the last
+// refresh drew it.
+func (s *State) printAbove(text string) error {
+	s.outputMutex.Lock()
+	defer s.outputMutex.Unlock()
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	if !s.prompting {
+		_, err := fmt.Fprint(s.w, text)
+		return err
+	}
+
+	if s.multiRow() && s.cursorRow > 0 {
+		s.cursorUp(s.cursorRow)
+	}
+	s.cursorPos(0)
+	s.eraseBelow()
+	_, err := fmt.Fprint(s.w, text)
+	s.cursorRow = 0
+	s.cursorCells = 0
+	s.renderColumns = s.columns
+	s.belowRows = 0
+	s.suggestion = s.shownSuggestion
+	s.refresh(s.shownPrompt, s.shownBuf, s.shownPos)
+	s.suggestion = ""
+	return err
+}
+
+// nextKey reads the next key. Other goroutines can print above the prompt
+// while it waits.
+func (s *State) nextKey() (interface{}, error) {
+	s.outputMutex.Unlock()
+	next, err := s.readNext()
+	s.outputMutex.Lock()
+	if next == winch {
+		s.getColumns()
+	}
+	return next, err
+}
+
+// unlocked calls f, which runs code of the application such as a KeyHandler
+// or a completer, without holding the output lock, so that f can call Printf
+// or RefreshPrompt. The prompt is left as the last refresh drew it until f
+// returns.
+func (s *State) unlocked(f func()) {
+	s.outputMutex.Unlock()
+	defer s.outputMutex.Lock()
+	f()
+}
+
+// complete calls the completer without holding the output lock.
+func (s *State) complete(line string, pos int) (head string, list []Completion, tail string) {
+	s.unlocked(func() { head, list, tail = s.completer(line, pos) })
+	return head, list, tail
+}
+
+// moveBelow moves the cursor to the start of the row below the prompt and
+// buffer.
+func (s *State) moveBelow() {
+	if !s.multiRow() {
+		fmt.Fprintln(s.w)
+	} else {
+		for i := s.cursorRow; i < s.nextRow; i++ {
+			fmt.Fprintln(s.w)
+		}
+		s.cursorRow = s.nextRow
+	}
+	if s.belowRows > 0 {
+		s.eraseBelow()
+		s.belowRows = 0
+	}
+}
+
 func (s *State) tabComplete(p string, line []rune, pos int) ([]rune, int, interface{}, error) {
 	if s.completer == nil {
 		return line, pos, rune(tab), nil
 	}
-	head, list, tail := s.completer(string(line), pos)
+	head, list, tail := s.complete(string(line), pos)
 	if len(list) <= 0 {
 		return line, pos, rune(tab), nil
 	}
 	listEntry := 0
-	hl := utf8.RuneCountInString(head)
 	for {
-		pick := list[listEntry]
-		s.refresh(p, head+pick+tail, hl+utf8.RuneCountInString(pick))
+		pick, pickPos := list[listEntry].apply(head, tail)
+		s.refresh(p, string(pick), pickPos)
 
-		next, err := s.readNext()
+		next, err := s.nextKey()
 		if err != nil {
 			return line, pos, rune(tab), err
 		}
@@ -178,7 +516,7 @@ func (s *State) tabComplete(p string, line []rune, pos int) ([]rune, int, interf
 			}
 			continue
 		}
-		return []rune(head + pick + tail), hl + utf8.RuneCountInString(pick), next, nil
+		return pick, pickPos, next, nil
 	}
 	// Not reached
 	return line, pos, rune(tab), nil
@@ -204,7 +542,7 @@ func (s *State) reverseISearch(origLine []rune, origPos int) ([]rune, int, inter
 	historyPos := len(history) - 1
 
 	for {
-		next, err := s.readNext()
+		next, err := s.nextKey()
 		if err != nil {
 			return []rune(foundLine), foundPos, esc, err
 		}
@@ -218,7 +556,7 @@ func (s *State) reverseISearch(origLine []rune, origPos int) ([]rune, int, inter
 					foundLine = history[historyPos]
 					foundPos = positions[historyPos]
 				} else {
-					fmt.Print(beep)
+					fmt.Fprint(s.w, beep)
 				}
 			case ctrlS: // Search forward
 				if historyPos < len(history)-1 && historyPos >= 0 {
@@ -226,14 +564,15 @@ func (s *State) reverseISearch(origLine []rune, origPos int) ([]rune, int, inter
 					foundLine = history[historyPos]
 					foundPos = positions[historyPos]
 				} else {
-					fmt.Print(beep)
+					fmt.Fprint(s.w, beep)
 				}
 			case ctrlH, bs: // Backspace
 				if pos <= 0 {
-					fmt.Print(beep)
+					fmt.Fprint(s.w, beep)
 				} else {
-					line = append(line[:pos-1], line[pos:]...)
-					pos--
+					n := prevGrapheme(line, pos)
+					line = append(line[:n], line[pos:]...)
+					pos = n
 
 					// For each char deleted, display the last matching line of history
 					history, positions := s.getHistoryByPattern(string(line))
@@ -327,14 +666,12 @@ func (s *State) yank(p string, text []rune, pos int) ([]rune, int, interface{},
 		pos = len(lineStart) + len(value)
 		s.refresh(p, string(line), pos)
 
-		next, err := s.readNext()
+		next, err := s.nextKey()
 		if err != nil {
 			return line, pos, next, err
 		}
 
 		switch v := next.(type) {
-		case rune:
-			return line, pos, next, nil
 		case action:
 			switch v {
 			case altY:
@@ -342,6 +679,8 @@ func (s *State) yank(p string, text []rune, pos int) ([]rune, int, interface{},
 			default:
 				return line, pos, next, nil
 			}
+		default:
+			return line, pos, next, nil
 		}
 	}
 
@@ -351,329 +690,155 @@ func (s *State) yank(p string, text []rune, pos int) ([]rune, int, interface{},
 // Prompt displays p, and then waits for user input. Prompt allows line editing
 // if the terminal supports it.
 func (s *State) Prompt(p string) (string, error) {
+	return s.PromptContext(context.Background(), p)
+}
+
+// PromptContext is like Prompt, but returns ctx.Err() as soon as ctx is done,
+// leaving the cursor on the row below the abandoned input. Nothing more is
+// read from the input then, so what is typed afterwards can be read by the
+// caller. This needs a terminal, a file, or an input with a SetReadDeadline
+// method such as a pipe or a network connection: other inputs given to
+// NewLinerWithConfig can only be read by a goroutine, and the rune it was
+// waiting for is read by the next prompt. On Windows, such inputs are read
+// whole lines at a time, and ctx is only checked before.
+func (s *State) PromptContext(ctx context.Context, p string) (string, error) {
+	return s.promptFuncContext(ctx, func() string { return p }, "", 0)
+}
+
+// PromptWithDefault is like Prompt, but starts with text in the line being
+// edited, and the cursor at pos, in runes from the start of text. A negative
+// pos, or one past the end of text, puts the cursor at the end.
+func (s *State) PromptWithDefault(p string, text string, pos int) (string, error) {
+	return s.promptFuncContext(context.Background(), func() string { return p }, text, pos)
+}
+
+// PromptFunc is like Prompt, but displays the prompt returned by f, which is
+// called again whenever RefreshPrompt is called while the prompt is in
+// progress.
+func (s *State) PromptFunc(f func() string) (string, error) {
+	return s.promptFuncContext(context.Background(), f, "", 0)
+}
+
+// RefreshPrompt draws the prompt in progress again, leaving the line being
+// edited and the cursor as they are. If the prompt was started by
+// PromptFunc, its function is called for the prompt to display. RefreshPrompt
+// does nothing if no prompt is in progress.
+//
+// Unlike the rest of liner's API, RefreshPrompt is safe to call from another
+// goroutine while Prompt is in progress, as well as from the KeyHandler,
+// Validator, completer or Suggester that the prompt is running.
+func (s *State) RefreshPrompt() {
+	s.outputMutex.Lock()
+	defer s.outputMutex.Unlock()
+	e := s.editor
+	if !s.prompting || e == nil {
+		return
+	}
+	shown := s.shownPrompt == e.p
+	e.setPrompt(e.promptFunc())
+	if shown {
+		// Otherwise, the prompt is replaced by that of a search or a
+		// completion, until it is drawn again
+		s.suggestion = s.shownSuggestion
+		s.refresh(e.p, s.shownBuf, s.shownPos)
+		s.suggestion = ""
+	}
+}
+
+func (s *State) promptFuncContext(ctx context.Context, f func() string, text string, pos int) (string, error) {
 	if !s.terminalOutput {
 		return "", errNotTerminalOutput
 	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	s.ctx = ctx
+	defer func() { s.ctx = nil }()
 	if !s.terminalSupported {
-		return s.promptUnsupported(p)
+		return s.promptUnsupported(f())
 	}
+	s.outputMutex.Lock()
+	s.prompting = true
+	defer func() {
+		s.prompting = false
+		s.outputMutex.Unlock()
+	}()
+	line, err := s.prompt(f, text, pos)
+	if err != nil && err == ctx.Err() {
+		s.moveBelow()
+	}
+	return line, err
+}
 
+func (s *State) prompt(f func() string, text string, pos int) (string, error) {
 	s.historyMutex.RLock()
 	defer s.historyMutex.RUnlock()
 
+	if m, ok := s.history().(HistoryMerger); ok {
+		m.Merge()
+	}
 	s.startPrompt()
 	s.getColumns()
-
-	fmt.Print(p)
-	var line []rune
-	pos := 0
-	var historyEnd string
-	prefixHistory := s.getHistoryByPrefix(string(line))
-	historyPos := len(prefixHistory)
-	var historyAction bool // used to mark history related actions
-	var killAction int = 0 // used to mark kill related actions
-mainLoop:
-	for {
-		historyAction = false
-		next, err := s.readNext()
-		if err != nil {
-			return "", err
+	s.enableBracketedPaste()
+	defer s.disableBracketedPaste()
+
+	km := s.keymap
+	p := f()
+	e := &Editor{s: s, promptFunc: f, prompt: p, p: p, searchPos: -1}
+	s.editor = e
+	defer func() {
+		s.editor = nil
+		s.message = ""
+	}()
+	// Erase the toolbar and the message when the line is not accepted, as
+	// moveBelow does when it is
+	defer s.drawBelow(nil)
+	if s.viMode {
+		if km == nil {
+			km = defaultViKeymap
 		}
-
-		// If the key is a tab do autocomplete, and then resume execution as usual
-		if key, ok := next.(rune); ok && key == tab {
-			line, pos, next, err = s.tabComplete(p, line, pos)
-			if err != nil {
-				return "", err
-			}
-			s.refresh(p, string(line), pos)
+		e.setViMode(ViInsert)
+	} else if km == nil {
+		km = defaultKeymap
+	}
+	s.printPrompt(e.p)
+	if text != "" {
+		if pos < 0 {
+			pos = len(text)
 		}
-
-		// If the key is a CtrlY && killring is not empty do yank. If yank returns
-		// next == ctrlY keep looping. Then resume normal execution
-		cont := true
-		for cont {
-			if key, ok := next.(rune); ok && key == ctrlY && s.killRing != nil {
-				line, pos, next, err = s.yank(p, line, pos)
-				if err != nil {
-					return "", err
-				}
-				if key, ok := next.(rune); !ok || key != ctrlY {
-					cont = false
-				}
-			} else {
-				cont = false
-			}
+		e.SetLine(text, pos)
+		e.refresh()
+	}
+	e.resetHistory()
+	for !e.accepted {
+		e.historyAction = false
+		e.inserted, e.undoAction = false, false
+		cmd, err := e.readCommand(km)
+		if err != nil {
+			return "", err
 		}
-
-		// If the key is a CtrlR do reverse intelligent search, then resume execution
-		if key, ok := next.(rune); ok && key == ctrlR {
-			line, pos, next, err = s.reverseISearch(line, pos)
-			if err != nil {
-				return "", err
+		before := e.snapshot()
+		if err := cmd(e); err != nil {
+			if err == ErrPromptAborted {
+				return string(e.line), err
 			}
-			s.refresh(p, string(line), pos)
+			return "", err
 		}
-
-		switch v := next.(type) {
-		case rune:
-			switch v {
-			case cr, lf:
-				fmt.Println()
-				break mainLoop
-			case ctrlA: // Start of line
-				pos = 0
-				s.refresh(p, string(line), pos)
-			case ctrlE: // End of line
-				pos = len(line)
-				s.refresh(p, string(line), pos)
-			case ctrlB: // left
-				if pos > 0 {
-					pos--
-					s.refresh(p, string(line), pos)
-				} else {
-					fmt.Print(beep)
-				}
-			case ctrlF: // right
-				if pos < len(line) {
-					pos++
-					s.refresh(p, string(line), pos)
-				} else {
-					fmt.Print(beep)
-				}
-			case ctrlD: // del
-				if pos == 0 && len(line) == 0 {
-					// exit
-					return "", io.EOF
-				}
-
-				// ctrlD is a potential EOF, so the rune reader shuts down.
-				// Therefore, if it isn't actually an EOF, we must re-startPrompt.
-				s.startPrompt()
-
-				if pos >= len(line) {
-					fmt.Print(beep)
-				} else {
-					line = append(line[:pos], line[pos+1:]...)
-					s.refresh(p, string(line), pos)
-				}
-			case ctrlK: // delete remainder of line
-				if pos >= len(line) {
-					fmt.Print(beep)
-				} else {
-					if killAction > 0 {
-						s.addToKillRing(line[pos:], 1) // Add in apend mode
-					} else {
-						s.addToKillRing(line[pos:], 0) // Add in normal mode
-					}
-
-					killAction = 2 // Mark that there was a kill action
-					line = line[:pos]
-					s.refresh(p, string(line), pos)
-				}
-			case ctrlP: // up
-				historyAction = true
-				if historyPos > 0 {
-					if historyPos == len(prefixHistory) {
-						historyEnd = string(line)
-					}
-					historyPos--
-					line = []rune(prefixHistory[historyPos])
-					pos = len(line)
-					s.refresh(p, string(line), pos)
-				} else {
-					fmt.Print(beep)
-				}
-			case ctrlN: // down
-				historyAction = true
-				if historyPos < len(prefixHistory) {
-					historyPos++
-					if historyPos == len(prefixHistory) {
-						line = []rune(historyEnd)
-					} else {
-						line = []rune(prefixHistory[historyPos])
-					}
-					pos = len(line)
-					s.refresh(p, string(line), pos)
-				} else {
-					fmt.Print(beep)
-				}
-			case ctrlT: // transpose prev rune with rune under cursor
-				if len(line) < 2 || pos < 1 {
-					fmt.Print(beep)
-				} else {
-					if pos == len(line) {
-						pos--
-					}
-					line[pos-1], line[pos] = line[pos], line[pos-1]
-					pos++
-					s.refresh(p, string(line), pos)
-				}
-			case ctrlL: // clear screen
-				s.eraseScreen()
-				s.refresh(p, string(line), pos)
-			case ctrlH, bs: // Backspace
-				if pos <= 0 {
-					fmt.Print(beep)
-				} else {
-					line = append(line[:pos-1], line[pos:]...)
-					pos--
-					s.refresh(p, string(line), pos)
-				}
-			case ctrlU: // Erase line before cursor
-				if killAction > 0 {
-					s.addToKillRing(line[:pos], 2) // Add in prepend mode
-				} else {
-					s.addToKillRing(line[:pos], 0) // Add in normal mode
-				}
-
-				killAction = 2 // Mark that there was some killing
-				line = line[pos:]
-				pos = 0
-				s.refresh(p, string(line), pos)
-			case ctrlW: // Erase word
-				if pos == 0 {
-					fmt.Print(beep)
-					break
-				}
-				// Remove whitespace to the left
-				buf := make([]rune, 0) // Store the deleted chars in a buffer
-				for {
-					if pos == 0 || !unicode.IsSpace(line[pos-1]) {
-						break
-					}
-					buf = append(buf, line[pos-1])
-					line = append(line[:pos-1], line[pos:]...)
-					pos--
-				}
-				// Remove non-whitespace to the left
-				for {
-					if pos == 0 || unicode.IsSpace(line[pos-1]) {
-						break
-					}
-					buf = append(buf, line[pos-1])
-					line = append(line[:pos-1], line[pos:]...)
-					pos--
-				}
-				// Invert the buffer and save the result on the killRing
-				newBuf := make([]rune, 0)
-				for i := len(buf) - 1; i >= 0; i-- {
-					newBuf = append(newBuf, buf[i])
-				}
-				if killAction > 0 {
-					s.addToKillRing(newBuf, 2) // Add in prepend mode
-				} else {
-					s.addToKillRing(newBuf, 0) // Add in normal mode
-				}
-				killAction = 2 // Mark that there was some killing
-
-				s.refresh(p, string(line), pos)
-			// Catch keys that do nothing, but you don't want them to beep
-			case esc:
-				// DO NOTHING
-			// Catch keys that are handled before the switch
-			case tab, ctrlR, ctrlY:
-				fallthrough
-			// Unused keys
-			case ctrlG, ctrlO, ctrlQ, ctrlS, ctrlV, ctrlX, ctrlZ:
-				fallthrough
-			// Catch unhandled control codes (anything <= 31)
-			case 0, ctrlC, 28, 29, 30, 31:
-				fmt.Print(beep)
-			default:
-				if pos == len(line) && len(p)+len(line) < s.columns-1 {
-					line = append(line, v)
-					fmt.Printf("%c", v)
-					pos++
-				} else {
-					line = append(line[:pos], append([]rune{v}, line[pos:]...)...)
-					pos++
-					s.refresh(p, string(line), pos)
-				}
-			}
-		case action:
-			switch v {
-			case del:
-				if pos >= len(line) {
-					fmt.Print(beep)
-				} else {
-					line = append(line[:pos], line[pos+1:]...)
-				}
-			case left:
-				if pos > 0 {
-					pos--
-				} else {
-					fmt.Print(beep)
-				}
-			case wordLeft:
-				if pos > 0 {
-					for {
-						pos--
-						if pos == 0 || unicode.IsSpace(line[pos-1]) {
-							break
-						}
-					}
-				} else {
-					fmt.Print(beep)
-				}
-			case right:
-				if pos < len(line) {
-					pos++
-				} else {
-					fmt.Print(beep)
-				}
-			case wordRight:
-				if pos < len(line) {
-					for {
-						pos++
-						if pos == len(line) || unicode.IsSpace(line[pos]) {
-							break
-						}
-					}
-				} else {
-					fmt.Print(beep)
-				}
-			case up:
-				historyAction = true
-				if historyPos > 0 {
-					if historyPos == len(prefixHistory) {
-						historyEnd = string(line)
-					}
-					historyPos--
-					line = []rune(prefixHistory[historyPos])
-					pos = len(line)
-				} else {
-					fmt.Print(beep)
-				}
-			case down:
-				historyAction = true
-				if historyPos < len(prefixHistory) {
-					historyPos++
-					if historyPos == len(prefixHistory) {
-						line = []rune(historyEnd)
-					} else {
-						line = []rune(prefixHistory[historyPos])
-					}
-					pos = len(line)
-				} else {
-					fmt.Print(beep)
-				}
-			case home: // Start of line
-				pos = 0
-			case end: // End of line
-				pos = len(line)
-			}
-			s.refresh(p, string(line), pos)
+		e.trackUndo(before)
+		if s.message != "" && string(before.line) != string(e.line) {
+			s.message = ""
+			e.refresh()
 		}
-		if !historyAction {
-			prefixHistory = s.getHistoryByPrefix(string(line))
-			historyPos = len(prefixHistory)
+		if !e.historyAction {
+			e.resetHistory()
 		}
-		if killAction > 0 {
-			killAction--
+		if e.killAction > 0 {
+			e.killAction--
 		}
 	}
-	return string(line), nil
+	s.moveBelow()
+	return string(e.line), nil
 }
 
 // PasswordPrompt displays p, and then waits for user input. The input typed by
@@ -686,16 +851,25 @@ func (s *State) PasswordPrompt(p string) (string, error) {
 		return "", errors.New("liner: function not supported in this terminal")
 	}
 
+	s.outputMutex.Lock()
+	s.prompting = true
+	defer func() {
+		s.prompting = false
+		s.outputMutex.Unlock()
+	}()
 	s.startPrompt()
 	s.getColumns()
+	s.enableBracketedPaste()
+	defer s.disableBracketedPaste()
+	defer s.drawBelow(nil)
 
-	fmt.Print(p)
+	s.printPrompt(p)
 	var line []rune
 	pos := 0
 
 mainLoop:
 	for {
-		next, err := s.readNext()
+		next, err := s.nextKey()
 		if err != nil {
 			return "", err
 		}
@@ -704,38 +878,57 @@ mainLoop:
 		case rune:
 			switch v {
 			case cr, lf:
-				fmt.Println()
+				s.moveBelow()
 				break mainLoop
 			case ctrlD: // del
 				if pos == 0 && len(line) == 0 {
 					// exit
 					return "", io.EOF
 				}
-
-				// ctrlD is a potential EOF, so the rune reader shuts down.
-				// Therefore, if it isn't actually an EOF, we must re-startPrompt.
-				s.startPrompt()
 			case ctrlL: // clear screen
 				s.eraseScreen()
+				s.cursorRow = 0
 				s.refresh(p, "", 0)
 			case ctrlH, bs: // Backspace
 				if pos <= 0 {
-					fmt.Print(beep)
+					fmt.Fprint(s.w, beep)
 				} else {
-					line = append(line[:pos-1], line[pos:]...)
-					pos--
+					n := prevGrapheme(line, pos)
+					line = append(line[:n], line[pos:]...)
+					pos = n
+				}
+			case ctrlC:
+				switch s.ctrlCMode {
+				case CtrlCAbort:
+					s.moveBelow()
+					return string(line), ErrPromptAborted
+				case CtrlCClear:
+					s.moveBelow()
+					line = nil
+					pos = 0
+					s.printPrompt(p)
+				default:
+					fmt.Fprint(s.w, beep)
 				}
 			// Unused keys
 			case esc, tab, ctrlA, ctrlB, ctrlE, ctrlF, ctrlG, ctrlK, ctrlN, ctrlO, ctrlP, ctrlQ, ctrlR, ctrlS,
 				ctrlT, ctrlU, ctrlV, ctrlW, ctrlX, ctrlY, ctrlZ:
 				fallthrough
 			// Catch unhandled control codes (anything <= 31)
-			case 0, ctrlC, 28, 29, 30, 31:
-				fmt.Print(beep)
+			case 0, 28, 29, 30, 31:
+				fmt.Fprint(s.w, beep)
 			default:
 				line = append(line[:pos], append([]rune{v}, line[pos:]...)...)
 				pos++
 			}
+		case pastedText:
+			if text, ok := s.pasteText(string(v)); ok {
+				r := []rune(text)
+				line = append(line[:pos], append(r, line[pos:]...)...)
+				pos += len(r)
+			} else {
+				fmt.Fprint(s.w, beep)
+			}
 		}
 	}
 	return string(line), nil
@@ -744,9 +937,58 @@ mainLoop:
 // Remove any ansi color sequences from a string, used for calculating
 // the length of the prompt.
 func stripAnsiColorSequences(in string) string {
-	if colorExpr == nil {
-		colorExpr = regexp.MustCompile("\x1b[^m]*m")
+	return colorExpr.ReplaceAllString(in, "")
+}
+
+// highlight returns buf styled by the highlighter, or buf itself if there is
+// no highlighter or it changed the text displayed.
+func (s *State) highlight(buf string, pos int) string {
+	if s.highlighter == nil {
+		return buf
+	}
+	styled := s.highlighter(buf, pos)
+	if stripAnsiColorSequences(styled) != buf {
+		return buf
 	}
+	return styled
+}
 
-	return colorExpr.ReplaceAllString(in, "")
+// fitRow returns row, which may contain color sequences, cut to width cells
+// if it is wider.
+func fitRow(row string, width int) string {
+	plain := stripAnsiColorSequences(row)
+	if stringWidth(plain) <= width {
+		return row
+	}
+	n := utf8.RuneCountInString(truncateWidth(plain, width))
+	if plain == row {
+		return string([]rune(row)[:n])
+	}
+	return sliceStyled(row, 0, n) + "\x1b[0m"
+}
+
+// sliceStyled returns the part of styled that displays the runes from start
+// to end of the text without color sequences. The color sequences before
+// end are kept, so that the part has the colors it has in styled.
+func sliceStyled(styled string, start, end int) string {
+	seqs := colorExpr.FindAllStringIndex(styled, -1)
+	var out []byte
+	i := 0 // runes displayed before b
+	for b := 0; b < len(styled); {
+		if len(seqs) > 0 && seqs[0][0] == b {
+			if i < end {
+				out = append(out, styled[b:seqs[0][1]]...)
+			}
+			b = seqs[0][1]
+			seqs = seqs[1:]
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(styled[b:])
+		if i >= start && i < end {
+			out = append(out, styled[b:b+size]...)
+		}
+		i++
+		b += size
+	}
+	return string(out)
 }
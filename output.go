@@ -13,22 +13,45 @@ import (
 func (s *State) cursorPos(x int) {
 	if s.useCHA {
 		// 'G' is "Cursor Character Absolute (CHA)"
-		fmt.Printf("\x1b[%dG", x+1)
+		fmt.Fprintf(s.w, "\x1b[%dG", x+1)
 	} else {
 		// 'C' is "Cursor Forward (CUF)"
-		fmt.Print("\r")
+		fmt.Fprint(s.w, "\r")
 		if x > 0 {
-			fmt.Printf("\x1b[%dC", x)
+			fmt.Fprintf(s.w, "\x1b[%dC", x)
 		}
 	}
 }
 
 func (s *State) eraseLine() {
-	fmt.Print("\x1b[0K")
+	fmt.Fprint(s.w, "\x1b[0K")
+}
+
+func (s *State) eraseBelow() {
+	fmt.Fprint(s.w, "\x1b[0J")
+}
+
+func (s *State) cursorUp(n int) {
+	fmt.Fprintf(s.w, "\x1b[%dA", n)
+}
+
+// forceWrap moves the cursor to the start of the next row after a row has
+// been completely filled, since VT100s leave the cursor on the last column
+// until the next character is printed.
+func (s *State) forceWrap() {
+	fmt.Fprint(s.w, "\n\r")
+}
+
+func (s *State) enableBracketedPaste() {
+	fmt.Fprint(s.w, "\x1b[?2004h")
+}
+
+func (s *State) disableBracketedPaste() {
+	fmt.Fprint(s.w, "\x1b[?2004l")
 }
 
 func (s *State) eraseScreen() {
-	fmt.Print("\x1b[H\x1b[2J")
+	fmt.Fprint(s.w, "\x1b[H\x1b[2J")
 }
 
 type winSize struct {
@@ -37,13 +60,22 @@ type winSize struct {
 }
 
 func (s *State) getColumns() {
+	if s.termSize != nil {
+		s.columns, s.rows = s.termSize()
+		return
+	}
+	if s.outFd < 0 {
+		s.columns, s.rows = 0, 0
+		return
+	}
 	var ws winSize
-	ok, _, _ := syscall.Syscall(syscall.SYS_IOCTL, uintptr(syscall.Stdout),
+	ok, _, _ := syscall.Syscall(syscall.SYS_IOCTL, uintptr(s.outFd),
 		syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
 	if ok < 0 {
 		s.columns = 80
 	}
 	s.columns = int(ws.col)
+	s.rows = int(ws.row)
 }
 
 func (s *State) checkOutput() {
@@ -36,6 +36,36 @@ func (s *State) eraseLine() {
 		uintptr(unsafe.Pointer(&numWritten)))
 }
 
+func (s *State) eraseBelow() {
+	var sbi consoleScreenBufferInfo
+	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
+	var numWritten uint32
+	cells := int(sbi.dwSize.x-sbi.dwCursorPosition.x) +
+		int(sbi.dwSize.x)*int(sbi.dwSize.y-sbi.dwCursorPosition.y-1)
+	procFillConsoleOutputCharacter.Call(uintptr(s.hOut), uintptr(' '),
+		uintptr(cells),
+		uintptr(int(sbi.dwCursorPosition.x)&0xFFFF|int(sbi.dwCursorPosition.y)<<16),
+		uintptr(unsafe.Pointer(&numWritten)))
+}
+
+func (s *State) cursorUp(n int) {
+	var sbi consoleScreenBufferInfo
+	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
+	procSetConsoleCursorPosition.Call(uintptr(s.hOut),
+		uintptr(int(sbi.dwCursorPosition.x)&0xFFFF|(int(sbi.dwCursorPosition.y)-n)<<16))
+}
+
+// forceWrap does nothing, because the console moves the cursor to the next
+// row as soon as the last column of a row is written.
+func (s *State) forceWrap() {
+}
+
+func (s *State) enableBracketedPaste() {
+}
+
+func (s *State) disableBracketedPaste() {
+}
+
 func (s *State) eraseScreen() {
 	var sbi consoleScreenBufferInfo
 	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
@@ -51,4 +81,5 @@ func (s *State) getColumns() {
 	var sbi consoleScreenBufferInfo
 	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
 	s.columns = int(sbi.dwSize.x)
+	s.rows = int(sbi.srWindow.bottom-sbi.srWindow.top) + 1
 }
@@ -0,0 +1,886 @@
+// +build !windows
+
+package liner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestLiner(input string, out *bytes.Buffer) *State {
+	return NewLinerWithConfig(Config{
+		Input:    strings.NewReader(input),
+		Output:   out,
+		TermSize: func() (int, int) { return 80, 24 },
+	})
+}
+
+func TestPromptWithConfig(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestLiner("hello\x02\x02J\r", &out)
+	defer s.Close()
+
+	line, err := s.Prompt("> ")
+	if err != nil {
+		t.Fatal("Unexpected error from Prompt", err)
+	}
+	if line != "helJlo" {
+		t.Fatalf("Expected line %q, got %q", "helJlo", line)
+	}
+	if !strings.HasPrefix(out.String(), "\x1b[?2004h> hello") {
+		t.Fatalf("Expected output to start with the prompt and input, got %q", out.String())
+	}
+
+	// Without TermSize, the line is read without editing
+	out.Reset()
+	s2 := NewLinerWithConfig(Config{Input: strings.NewReader("hello\n"), Output: &out})
+	defer s2.Close()
+	if line, err := s2.Prompt("> "); err != nil || line != "hello" || out.String() != "> " {
+		t.Fatalf("Unexpected unedited prompt result %q, %v, %q", line, err, out.String())
+	}
+}
+
+func TestConcurrentStates(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var out bytes.Buffer
+			s := newTestLiner("hello\r", &out)
+			defer s.Close()
+			if line, err := s.Prompt("\x1b[1m>\x1b[0m "); err != nil || line != "hello" {
+				t.Errorf("Expected %q, got %q, %v", "hello", line, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMultiLineMode(t *testing.T) {
+	var out bytes.Buffer
+	s := NewLinerWithConfig(Config{
+		Input:    strings.NewReader("abcdefghijklmnop\x01\r"),
+		Output:   &out,
+		TermSize: func() (int, int) { return 10, 24 },
+	})
+	defer s.Close()
+	s.SetMultiLineMode(true)
+
+	line, err := s.Prompt("> ")
+	if err != nil {
+		t.Fatal("Unexpected error from Prompt", err)
+	}
+	if line != "abcdefghijklmnop" {
+		t.Fatalf("Expected line %q, got %q", "abcdefghijklmnop", line)
+	}
+	if strings.Contains(out.String(), "{") {
+		t.Fatalf("Unexpected scroll marker in multi-line output %q", out.String())
+	}
+	// Accepting the line from its first row must move the cursor below
+	// the second
+	if !strings.HasSuffix(out.String(), "> abcdefghijklmnop\x1b[1A\r\x1b[2C\n\n\x1b[?2004l") {
+		t.Fatalf("Expected the cursor to move below the line on accept, got %q", out.String())
+	}
+}
+
+func TestPromptWideCursor(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestLiner("日本語\x02\r", &out)
+	defer s.Close()
+
+	line, err := s.Prompt("> ")
+	if err != nil {
+		t.Fatal("Unexpected error from Prompt", err)
+	}
+	if line != "日本語" {
+		t.Fatalf("Unexpected line %q", line)
+	}
+	// The cursor sits before the third ideograph: 2 cells of prompt plus
+	// 2 double width runes
+	if !strings.HasSuffix(out.String(), "\x1b[0K\r\x1b[6C\n\x1b[?2004l") {
+		t.Fatalf("Expected the cursor in column 6, got %q", out.String())
+	}
+}
+
+func TestPromptGraphemeEditing(t *testing.T) {
+	tests := []struct {
+		input, expected string
+	}{
+		// Backspace removes a whole flag
+		{"x\U0001f1ef\U0001f1f5\x7f\r", "x"},
+		// Left moves over base and combining mark together
+		{"ae\u0301\x02z\r", "aze\u0301"},
+		// Ctrl-T swaps clusters, not runes
+		{"ae\u0301\x14\r", "e\u0301a"},
+		// Ctrl-D deletes a ZWJ sequence
+		{"\U0001f468\u200d\U0001f469x\x01\x04\r", "x"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		s := newTestLiner(test.input, &out)
+		line, err := s.Prompt("> ")
+		s.Close()
+		if err != nil {
+			t.Fatalf("Unexpected error from Prompt for %q: %s", test.input, err)
+		}
+		if line != test.expected {
+			t.Errorf("Expected %q for input %q, got %q", test.expected, test.input, line)
+		}
+	}
+}
+
+func TestPromptContext(t *testing.T) {
+	// Pipes from os.Pipe support read deadlines; those of syscall.Pipe
+	// are waited on until they are readable
+	pipes := map[string]func() (*os.File, *os.File, error){
+		"os.Pipe": os.Pipe,
+		"syscall.Pipe": func() (*os.File, *os.File, error) {
+			var fds [2]int
+			if err := syscall.Pipe(fds[:]); err != nil {
+				return nil, nil, err
+			}
+			return os.NewFile(uintptr(fds[0]), "r"), os.NewFile(uintptr(fds[1]), "w"), nil
+		},
+	}
+	for name, pipe := range pipes {
+		for _, editing := range []bool{true, false} {
+			inr, inw, err := pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := Config{Input: inr, Output: ioutil.Discard}
+			if editing {
+				cfg.TermSize = func() (int, int) { return 80, 24 }
+			}
+			s := NewLinerWithConfig(cfg)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			_, err = s.PromptContext(ctx, "> ")
+			cancel()
+			if err != context.DeadlineExceeded {
+				t.Fatalf("%s, editing %t: expected %v, got %v", name, editing, context.DeadlineExceeded, err)
+			}
+
+			// Input typed after the timeout is left for the caller
+			io.WriteString(inw, "abc\n")
+			buf := make([]byte, 10)
+			if n, err := inr.Read(buf); err != nil || string(buf[:n]) != "abc\n" {
+				t.Fatalf("%s, editing %t: expected the caller to read %q, got %q, %v", name, editing, "abc\n", buf[:n], err)
+			}
+
+			io.WriteString(inw, "def\n")
+			if line, err := s.Prompt("> "); err != nil || line != "def" {
+				t.Fatalf("%s, editing %t: expected %q, got %q, %v", name, editing, "def", line, err)
+			}
+			s.Close()
+			inr.Close()
+			inw.Close()
+		}
+	}
+}
+
+func TestCtrlCMode(t *testing.T) {
+	tests := []struct {
+		mode     CtrlCMode
+		input    string
+		expected string
+		err      error
+	}{
+		{CtrlCBeep, "ab\x03c\r", "abc", nil},
+		{CtrlCAbort, "ab\x03c\r", "ab", ErrPromptAborted},
+		{CtrlCClear, "ab\x03c\r", "c", nil},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		s := newTestLiner(test.input, &out)
+		s.SetCtrlCMode(test.mode)
+		line, err := s.Prompt("> ")
+		s.Close()
+		if err != test.err {
+			t.Errorf("Expected error %v in mode %d, got %v", test.err, test.mode, err)
+		}
+		if line != test.expected {
+			t.Errorf("Expected %q in mode %d, got %q", test.expected, test.mode, line)
+		}
+	}
+}
+
+func TestKeymap(t *testing.T) {
+	km := DefaultKeymap()
+	if err := km.Bind("Ctrl-X Ctrl-A", "end-of-line"); err != nil {
+		t.Fatal(err)
+	}
+	if err := km.BindFunc("Ctrl-O", func(e *Editor) {
+		e.SetLine(strings.ToUpper(e.Line()), e.Pos())
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := km.BindFunc("F2", func(e *Editor) {
+		e.Insert("!")
+		e.Accept()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := km.Unbind("Ctrl-B"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"ab\x01\x18\x01c\r", "abc"},
+		{"ab\x0fc\r", "ABc"},
+		{"ab\x01\x1bOQ", "!ab"},
+		{"ab\x02c\r", "abc"},
+		// Ctrl-X starts a sequence that c doesn't continue
+		{"ab\x18c\r", "abc"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		s := newTestLiner(test.input, &out)
+		s.SetKeymap(km)
+		line, err := s.Prompt("> ")
+		s.Close()
+		if err != nil {
+			t.Errorf("Unexpected error from Prompt for %q: %v", test.input, err)
+		}
+		if line != test.expected {
+			t.Errorf("Expected %q for %q, got %q", test.expected, test.input, line)
+		}
+	}
+}
+
+func TestViMode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"hello world\x1bbdwi\r", "hello "},
+		{"one two three\x1b02wcwX\x1b0x\r", "ne two X"},
+		{"one two\x1b0cwX\x1b\r", "X two"},
+		{"abc\x1bxxu\r", "ab"},
+		{"abc\x1bxu\x12\r", "ab"},
+		{"foo bar\x1b0ywP\r", "foo foo bar"},
+		{"foo bar\x1b0dwp\r", "bfoo ar"},
+		{"abcd\x1b03rx\r", "xxxd"},
+		{"abc def\x1b0wd$\r", "abc "},
+		{"abc def\x1bddiX\r", "X"},
+		{"abc def\x1b0ea!\x1bu\r", "abc def"},
+		{"\x1bk\r", "second cmd"},
+		{"\x1b2k\r", "first cmd"},
+		{"\x1b/first\r\r", "first cmd"},
+		{"\x1b/cmd\rn\r", "first cmd"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		s := newTestLiner(test.input, &out)
+		s.SetViMode(true)
+		s.AppendHistory("first cmd")
+		s.AppendHistory("second cmd")
+		line, err := s.Prompt("> ")
+		s.Close()
+		if err != nil {
+			t.Errorf("Unexpected error from Prompt for %q: %v", test.input, err)
+		}
+		if line != test.expected {
+			t.Errorf("Expected %q for %q, got %q", test.expected, test.input, line)
+		}
+	}
+}
+
+func TestViModeHook(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestLiner("a\x1bi\r", &out)
+	defer s.Close()
+	s.SetViMode(true)
+	var modes []ViMode
+	s.SetViModeHook(func(mode ViMode, prompt string) string {
+		modes = append(modes, mode)
+		if mode == ViCommand {
+			return "[N] " + prompt
+		}
+		return prompt
+	})
+
+	if _, err := s.Prompt("> "); err != nil {
+		t.Fatal("Unexpected error from Prompt", err)
+	}
+	if len(modes) != 3 || modes[0] != ViInsert || modes[1] != ViCommand || modes[2] != ViInsert {
+		t.Fatalf("Unexpected mode changes %v", modes)
+	}
+	if !strings.Contains(out.String(), "[N] > a") {
+		t.Fatalf("Expected the command mode prompt in %q", out.String())
+	}
+}
+
+func TestUndo(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"abc def\x17\x1f\r", "abc def"},
+		{"abc\x02d\x1f\r", "abc"},
+		{"abc\x02d\x1f\x1f\r", ""},
+		{"ab\x15\x1f\x1b\x1f\r", ""},
+		{"ab\x18\x15\r", ""},
+		{"ab\x1fc\x1b\x1f\r", "c"},
+		{"h\x10\x1f\r", "h"},
+		{"ab\x02\x14\x1f\r", "ab"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		s := newTestLiner(test.input, &out)
+		s.AppendHistory("history")
+		line, err := s.Prompt("> ")
+		s.Close()
+		if err != nil {
+			t.Errorf("Unexpected error from Prompt for %q: %v", test.input, err)
+		}
+		if line != test.expected {
+			t.Errorf("Expected %q for %q, got %q", test.expected, test.input, line)
+		}
+	}
+}
+
+func TestTabPrints(t *testing.T) {
+	tables := []string{"tab", "table1", "table2"}
+	many := []string{"aaaaaaa1", "aaaaaaa2", "aaaaaaa3", "aaaaaaa4", "aaaaaaa5"}
+	tests := []struct {
+		input      string
+		list       []string
+		columns    int
+		queryItems int
+		expected   string
+		shown      []string
+		hidden     []string
+	}{
+		{"t\t\t1\r", tables, 80, 0, "tab1", []string{"tab     table1  table2\n"}, nil},
+		{"t\tx\r", tables, 80, 0, "tabx", nil, []string{"table1"}},
+		{"t\t\tn\r", tables, 80, 3, "tab", []string{"Display all 3 possibilities? (y or n)"}, []string{"table1"}},
+		{"t\t\ty\r", tables, 80, 3, "tab", []string{"table1"}, nil},
+		{"a\t\tq\r", many, 10, 0, "aaaaaaa", []string{"aaaaaaa2\n--More--"}, []string{"aaaaaaa3"}},
+		{"a\t\t \r\r", many, 10, 0, "aaaaaaa", []string{"aaaaaaa5\n"}, nil},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		columns := test.columns
+		s := NewLinerWithConfig(Config{
+			Input:    strings.NewReader(test.input),
+			Output:   &out,
+			TermSize: func() (int, int) { return columns, 3 },
+		})
+		list := test.list
+		s.SetCompleter(func(string) []string { return list })
+		s.SetTabCompletionStyle(TabPrints)
+		s.SetCompletionQueryItems(test.queryItems)
+		line, err := s.Prompt("> ")
+		s.Close()
+		if err != nil {
+			t.Errorf("Unexpected error from Prompt for %q: %v", test.input, err)
+		}
+		if line != test.expected {
+			t.Errorf("Expected %q for %q, got %q", test.expected, test.input, line)
+		}
+		for _, shown := range test.shown {
+			if !strings.Contains(out.String(), shown) {
+				t.Errorf("Expected %q in the output for %q, got %q", shown, test.input, out.String())
+			}
+		}
+		for _, hidden := range test.hidden {
+			if strings.Contains(out.String(), hidden) {
+				t.Errorf("Unexpected %q in the output for %q, got %q", hidden, test.input, out.String())
+			}
+		}
+	}
+}
+
+func TestTabMenu(t *testing.T) {
+	names := []string{"alpha", "beta", "gamma"}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"\t\t\r\r", "beta"},
+		{"\t\x1b[Z\x1b[Z\r\r", "beta"},
+		{"\t\x1b[C\r\r", "beta"},
+		{"\tg\r\r", "gamma"},
+		{"\tgx\r", "gx"},
+		{"\t\t\x1b\r", ""},
+		{"\t\x01x\r", "xalpha"},
+		{"a\t\r", "alpha"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		s := newTestLiner(test.input, &out)
+		s.SetCompleter(func(line string) (c []string) {
+			for _, n := range names {
+				if strings.HasPrefix(n, line) {
+					c = append(c, n)
+				}
+			}
+			return
+		})
+		s.SetTabCompletionStyle(TabMenu)
+		line, err := s.Prompt("> ")
+		s.Close()
+		if err != nil {
+			t.Errorf("Unexpected error from Prompt for %q: %v", test.input, err)
+		}
+		if line != test.expected {
+			t.Errorf("Expected %q for %q, got %q", test.expected, test.input, line)
+		}
+		if out := out.String(); test.input[:2] == "\t\t" && !strings.Contains(out, "\x1b[7m beta  \x1b[0m") {
+			t.Errorf("Expected beta to be highlighted for %q, got %q", test.input, out)
+		}
+	}
+
+	// The menu leaves a row for the validation message
+	var out bytes.Buffer
+	s := NewLinerWithConfig(Config{
+		Input:    strings.NewReader("\r\t\r\r"),
+		Output:   &out,
+		TermSize: func() (int, int) { return 8, 3 },
+	})
+	defer s.Close()
+	s.SetCompleter(func(line string) []string { return names })
+	s.SetTabCompletionStyle(TabMenu)
+	s.SetValidator(func(line string) error {
+		if line == "" {
+			return errors.New("empty")
+		}
+		return nil
+	})
+	if line, err := s.Prompt("> "); err != nil || line != "alpha" {
+		t.Fatalf("Expected %q, got %q, %v", "alpha", line, err)
+	}
+	if !strings.Contains(out.String(), "\x1b[0J\x1b[7m alpha \x1b[0m\nempty\x1b[2A") {
+		t.Errorf("Expected one menu row above the message, got %q", out.String())
+	}
+}
+
+func TestRichCompleter(t *testing.T) {
+	keywords := []Completion{
+		{Text: "SELECT", Description: "query rows", Suffix: " "},
+		{Text: "SET", Description: "change a setting", Suffix: " "},
+	}
+	var out bytes.Buffer
+	s := newTestLiner("S\t\t\r"+"SEL\t\r", &out)
+	defer s.Close()
+	s.SetRichCompleter(func(line string, pos int) (string, []Completion, string) {
+		var c []Completion
+		for _, k := range keywords {
+			if strings.HasPrefix(k.Text, line[:pos]) {
+				c = append(c, k)
+			}
+		}
+		return "", c, line[pos:]
+	})
+	s.SetTabCompletionStyle(TabPrints)
+
+	line, err := s.Prompt("> ")
+	if err != nil || line != "SE" {
+		t.Fatalf("Expected %q, got %q, %v", "SE", line, err)
+	}
+	if !strings.Contains(out.String(), "SELECT  query rows\nSET     change a setting\n") {
+		t.Fatalf("Expected aligned descriptions, got %q", out.String())
+	}
+	line, err = s.Prompt("> ")
+	if err != nil || line != "SELECT " {
+		t.Fatalf("Expected %q, got %q, %v", "SELECT ", line, err)
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	tests := []struct {
+		input     string
+		multiLine bool
+		expected  string
+	}{
+		{"git c\x1b[C\r", false, "git commit -m fix"},
+		{"git c\x1b[C\r", true, "git commit -m fix"},
+		{"git\x1bf\r", false, "git commit"},
+		{"git\x1bf\x1bf\r", false, "git commit -m"},
+		{"git s\x05\r", false, "git status"},
+		{"git c\r", false, "git c"},
+		{"git x\x1b[C\r", false, "git x"},
+		{"git s\x01\x1b[C\r", false, "git s"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		s := newTestLiner(test.input, &out)
+		s.AppendHistory("git status")
+		s.AppendHistory("git commit -m fix")
+		s.SuggestHistory()
+		s.SetMultiLineMode(test.multiLine)
+		line, err := s.Prompt("> ")
+		s.Close()
+		if err != nil {
+			t.Errorf("Unexpected error from Prompt for %q: %v", test.input, err)
+		}
+		if line != test.expected {
+			t.Errorf("Expected %q for %q, got %q", test.expected, test.input, line)
+		}
+		if test.input == "git c\r" && !strings.Contains(out.String(), "git c\x1b[2mommit -m fix\x1b[0m") {
+			t.Errorf("Expected a dimmed suggestion, got %q", out.String())
+		}
+	}
+
+	var out bytes.Buffer
+	s := newTestLiner("he\x06\r", &out)
+	defer s.Close()
+	s.SetSuggester(func(line string) string {
+		if strings.HasPrefix("hello world", line) {
+			return "hello world"
+		}
+		return ""
+	})
+	if line, err := s.Prompt("> "); err != nil || line != "hello world" {
+		t.Fatalf("Expected %q, got %q, %v", "hello world", line, err)
+	}
+}
+
+func TestHighlighter(t *testing.T) {
+	numbers := regexp.MustCompile("[0-9]+")
+	highlight := func(line string, pos int) string {
+		return numbers.ReplaceAllString(line, "\x1b[33m$0\x1b[0m")
+	}
+
+	var out bytes.Buffer
+	s := newTestLiner("select 42\r", &out)
+	s.SetHighlighter(highlight)
+	line, err := s.Prompt("> ")
+	s.Close()
+	if err != nil || line != "select 42" {
+		t.Fatalf("Expected %q, got %q, %v", "select 42", line, err)
+	}
+	if !strings.HasSuffix(out.String(), "> select \x1b[33m42\x1b[0m\x1b[0m\x1b[0K\r\x1b[11C\n\x1b[?2004l") {
+		t.Fatalf("Unexpected output %q", out.String())
+	}
+
+	// Scrolled horizontally, the cursor is placed on the visible columns
+	out.Reset()
+	s = NewLinerWithConfig(Config{
+		Input:    strings.NewReader("1234567890abcdefghij\x02\x02\r"),
+		Output:   &out,
+		TermSize: func() (int, int) { return 12, 24 },
+	})
+	s.SetHighlighter(highlight)
+	line, err = s.Prompt("> ")
+	s.Close()
+	if err != nil || line != "1234567890abcdefghij" {
+		t.Fatalf("Expected %q, got %q, %v", "1234567890abcdefghij", line, err)
+	}
+	if !strings.HasSuffix(out.String(), "> {\x1b[33m\x1b[0mcdefghij\x1b[0m\x1b[0K\r\x1b[9C\n\x1b[?2004l") {
+		t.Fatalf("Unexpected output %q", out.String())
+	}
+
+	// A highlighter that changes the text is ignored
+	out.Reset()
+	s = newTestLiner("abc\r", &out)
+	s.SetHighlighter(func(line string, pos int) string { return "x" + line })
+	s.Prompt("> ")
+	s.Close()
+	if strings.Contains(out.String(), "xabc") {
+		t.Fatalf("Unexpected output %q", out.String())
+	}
+}
+
+func TestBracketedPaste(t *testing.T) {
+	tests := []struct {
+		mode     PasteMode
+		expected string
+	}{
+		{PasteSpaces, "[a\tb c\tx\x1b[1m]"},
+		{PasteNewlines, "[a\tb\nc\tx\x1b[1m]"},
+		{PasteReject, "[]"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		s := newTestLiner("[]\x02\x1b[200~a\tb\r\nc\tx\x1b[1m\x1b[201~\r", &out)
+		s.SetPasteMode(test.mode)
+		line, err := s.Prompt("> ")
+		s.Close()
+		if err != nil || line != test.expected {
+			t.Errorf("Mode %d: expected %q, got %q, %v", test.mode, test.expected, line, err)
+		}
+		if !strings.HasPrefix(out.String(), "\x1b[?2004h") || !strings.HasSuffix(out.String(), "\x1b[?2004l") {
+			t.Errorf("Mode %d: expected bracketed paste mode around the prompt, got %q", test.mode, out.String())
+		}
+		// Control characters are shown as symbols, one cell wide
+		if test.mode == PasteSpaces && !strings.Contains(out.String(), "\r> [a\u2409b c\u2409x\u241b[1m]\x1b[0K\r\x1b[14C") {
+			t.Errorf("Expected the control characters to be shown as symbols, got %q", out.String())
+		}
+	}
+
+	var out bytes.Buffer
+	s := newTestLiner("\x1b[200~se\x03cret\x1b[201~\r", &out)
+	defer s.Close()
+	if line, err := s.PasswordPrompt("> "); err != nil || line != "se\x03cret" {
+		t.Fatalf("Expected %q, got %q, %v", "se\x03cret", line, err)
+	}
+}
+
+func TestMultiLineInput(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestLiner("ab\rcd;\x10X\x0e\x02Z\r", &out)
+	defer s.Close()
+	var checked []string
+	s.SetMultiLineInput("... ", func(input string) bool {
+		checked = append(checked, input)
+		return strings.HasSuffix(input, ";")
+	})
+
+	line, err := s.Prompt("> ")
+	if err != nil || line != "abX\ncdZ;" {
+		t.Fatalf("Expected %q, got %q, %v", "abX\ncdZ;", line, err)
+	}
+	if len(checked) != 2 || checked[0] != "ab" {
+		t.Errorf("Unexpected completeness checks %q", checked)
+	}
+	if !strings.Contains(out.String(), "> abX\r\n... cdZ;") {
+		t.Errorf("Expected the continuation prompt on the second row, got %q", out.String())
+	}
+}
+
+func TestPrintfAbovePrompt(t *testing.T) {
+	r, w := io.Pipe()
+	var out bytes.Buffer
+	s := NewLinerWithConfig(Config{
+		Input:    r,
+		Output:   &out,
+		TermSize: func() (int, int) { return 80, 24 },
+	})
+	defer s.Close()
+
+	printed := make(chan struct{})
+	km := DefaultKeymap()
+	km.BindFunc("Ctrl-G", func(e *Editor) {
+		go func() {
+			fmt.Fprintf(s.Writer(), "log %d", 1)
+			close(printed)
+		}()
+	})
+	km.BindFunc("Ctrl-T", func(e *Editor) {
+		s.Printf("help")
+	})
+	s.SetKeymap(km)
+	go func() {
+		io.WriteString(w, "ab\x02\x07")
+		<-printed
+		io.WriteString(w, "\x14\r")
+	}()
+
+	line, err := s.Prompt("> ")
+	if err != nil || line != "ab" {
+		t.Fatalf("Expected %q, got %q, %v", "ab", line, err)
+	}
+	if !strings.Contains(out.String(), "\r\x1b[0Jlog 1\n\r> ab\x1b[0K\r\x1b[3C") {
+		t.Fatalf("Expected the line to be drawn again below the output, got %q", out.String())
+	}
+	// From a KeyHandler, it prints without waiting for the prompt
+	if !strings.Contains(out.String(), "\r\x1b[0Jhelp\n\r> ab\x1b[0K\r\x1b[3C") {
+		t.Fatalf("Expected the output of the KeyHandler above the line, got %q", out.String())
+	}
+
+	// Without a prompt in progress, the output is written as is
+	out.Reset()
+	if n, err := s.Printf("%s\n", "done"); n != 5 || err != nil || out.String() != "done\n" {
+		t.Fatalf("Unexpected Printf result %d, %v, %q", n, err, out.String())
+	}
+}
+
+func TestRightPrompt(t *testing.T) {
+	for _, multiLine := range []bool{false, true} {
+		var out bytes.Buffer
+		s := NewLinerWithConfig(Config{
+			Input:    strings.NewReader("abcdefghijklm\r"),
+			Output:   &out,
+			TermSize: func() (int, int) { return 20, 24 },
+		})
+		s.SetMultiLineMode(multiLine)
+		s.SetRightPrompt("\x1b[1m[db]\x1b[0m")
+		line, err := s.Prompt("> ")
+		s.Close()
+		if err != nil || line != "abcdefghijklm" {
+			t.Fatalf("Expected %q, got %q, %v", "abcdefghijklm", line, err)
+		}
+		// The right prompt ends one column before the right edge, and is
+		// shown until the line reaches the column before it
+		shown := strings.Split(out.String(), "\r\x1b[15C\x1b[1m[db]\x1b[0m")
+		if len(shown)-1 != 13 {
+			t.Errorf("Multi-line mode %t: expected the right prompt to be drawn 13 times, got %d in %q",
+				multiLine, len(shown)-1, out.String())
+		}
+	}
+}
+
+func TestRefreshPrompt(t *testing.T) {
+	r, w := io.Pipe()
+	var out bytes.Buffer
+	s := NewLinerWithConfig(Config{
+		Input:    r,
+		Output:   &out,
+		TermSize: func() (int, int) { return 80, 24 },
+	})
+	defer s.Close()
+
+	var mu sync.Mutex
+	status := "offline"
+	refreshed := make(chan struct{})
+	km := DefaultKeymap()
+	km.BindFunc("Ctrl-G", func(e *Editor) {
+		go func() {
+			mu.Lock()
+			status = "online"
+			mu.Unlock()
+			s.RefreshPrompt()
+			close(refreshed)
+		}()
+	})
+	km.BindFunc("Ctrl-T", func(e *Editor) {
+		mu.Lock()
+		status = "busy"
+		mu.Unlock()
+		s.RefreshPrompt()
+	})
+	s.SetKeymap(km)
+	go func() {
+		io.WriteString(w, "ab\x02\x07")
+		<-refreshed
+		io.WriteString(w, "c\x14\r")
+	}()
+
+	line, err := s.PromptFunc(func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return status + "> "
+	})
+	if err != nil || line != "acb" {
+		t.Fatalf("Expected %q, got %q, %v", "acb", line, err)
+	}
+	if !strings.Contains(out.String(), "\ronline> ab\x1b[0K\r\x1b[9C\ronline> acb") {
+		t.Fatalf("Expected the new prompt with the line and cursor unchanged, got %q", out.String())
+	}
+	// From a KeyHandler, it redraws without waiting for the prompt
+	if !strings.Contains(out.String(), "\rbusy> acb\x1b[0K\r\x1b[8C") {
+		t.Fatalf("Expected the prompt set by the KeyHandler, got %q", out.String())
+	}
+}
+
+func TestToolbar(t *testing.T) {
+	var out bytes.Buffer
+	s := NewLinerWithConfig(Config{
+		Input:    strings.NewReader("a\r"),
+		Output:   &out,
+		TermSize: func() (int, int) { return 10, 24 },
+	})
+	defer s.Close()
+	s.SetToolbar("\x1b[7m[F1] help\x1b[0m", "insert mode")
+
+	line, err := s.Prompt("> ")
+	if err != nil || line != "a" {
+		t.Fatalf("Expected %q, got %q, %v", "a", line, err)
+	}
+	// The rows are cut to the terminal width, and erased on accept
+	expected := "\r> \x1b[0K\r\x1b[2C\n\x1b[0J\x1b[7m[F1] help\x1b[0m\ninsert mo\x1b[2A\r\x1b[2Ca\n\x1b[0J\x1b[?2004l"
+	if !strings.HasSuffix(out.String(), expected) {
+		t.Fatalf("Expected output to end with %q, got %q", expected, out.String())
+	}
+
+	// and when the prompt ends without a line
+	for _, input := range []string{"\x04", ""} {
+		out.Reset()
+		s := newTestLiner(input, &out)
+		s.SetToolbar("hint")
+		if _, err := s.Prompt("> "); err != io.EOF {
+			t.Fatalf("Expected io.EOF for %q, got %v", input, err)
+		}
+		s.Close()
+		expected := "hint\x1b[1A\r\x1b[2C\n\x1b[0J\x1b[1A\r\x1b[2C\x1b[?2004l"
+		if !strings.HasSuffix(out.String(), expected) {
+			t.Fatalf("Expected output to end with %q for %q, got %q", expected, input, out.String())
+		}
+	}
+}
+
+func TestValidator(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestLiner("12a\r\x04\r", &out)
+	defer s.Close()
+	var validated []string
+	s.SetValidator(func(line string) error {
+		validated = append(validated, line)
+		for i, r := range line {
+			if r < '0' || r > '9' {
+				return &ValidationError{Message: "not a number", Pos: i}
+			}
+		}
+		return nil
+	})
+
+	line, err := s.Prompt("> ")
+	if err != nil || line != "12" {
+		t.Fatalf("Expected %q, got %q, %v", "12", line, err)
+	}
+	if len(validated) != 2 || validated[0] != "12a" {
+		t.Errorf("Unexpected validated lines %q", validated)
+	}
+	// The message is shown with the cursor on the invalid character, and
+	// erased once the line changes
+	if !strings.Contains(out.String(), "\r> 12a\x1b[0K\r\x1b[4C\n\x1b[0Jnot a number\x1b[1A\r\x1b[4C\a") {
+		t.Errorf("Expected the message below the line, got %q", out.String())
+	}
+	if !strings.HasSuffix(out.String(), "\r> 12\x1b[0K\r\x1b[4C\n\x1b[0J\x1b[1A\r\x1b[4C\n\x1b[?2004l") {
+		t.Errorf("Expected the message to be erased, got %q", out.String())
+	}
+
+	// Also when the line is changed in vi command mode
+	out.Reset()
+	s2 := newTestLiner("12a\r\x1b$x\r", &out)
+	defer s2.Close()
+	s2.SetViMode(true)
+	s2.SetValidator(s.validator)
+	if line, err := s2.Prompt("> "); err != nil || line != "12" {
+		t.Fatalf("Expected %q, got %q, %v", "12", line, err)
+	}
+	if strings.Contains(out.String(), "\r> 12\x1b[0K\r\x1b[3C\n\x1b[0Jnot a number") {
+		t.Errorf("Expected the message to be erased in command mode, got %q", out.String())
+	}
+}
+
+func TestPromptWithDefault(t *testing.T) {
+	tests := []struct {
+		input, text string
+		pos         int
+		expected    string
+	}{
+		{"X\r", "hello", 2, "heXllo"},
+		{"X\r", "hello", -1, "helloX"},
+		{"X\r", "hello", 9, "helloX"},
+		{"\x17\x19\x19\r", "foo bar", -1, "foo barbar"},
+		{"\x01\x0b\r", "foo bar", 3, ""},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		s := newTestLiner(test.input, &out)
+		line, err := s.PromptWithDefault("> ", test.text, test.pos)
+		s.Close()
+		if err != nil || line != test.expected {
+			t.Errorf("PromptWithDefault(%q, %d) with input %q: expected %q, got %q, %v",
+				test.text, test.pos, test.input, test.expected, line, err)
+		}
+	}
+}
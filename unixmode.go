@@ -8,7 +8,11 @@ import (
 )
 
 func (mode *termios) ApplyMode() error {
-	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(syscall.Stdin), setTermios, uintptr(unsafe.Pointer(mode)))
+	return mode.applyMode(syscall.Stdin)
+}
+
+func (mode *termios) applyMode(fd int) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), setTermios, uintptr(unsafe.Pointer(mode)))
 
 	if errno != 0 {
 		return errno
@@ -21,8 +25,16 @@ func (mode *termios) ApplyMode() error {
 // This function is provided for convenience, and should
 // not be necessary for most users of liner.
 func TerminalMode() (ModeApplier, error) {
+	mode, err := terminalMode(syscall.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	return mode, nil
+}
+
+func terminalMode(fd int) (*termios, error) {
 	var mode termios
-	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(syscall.Stdin), getTermios, uintptr(unsafe.Pointer(&mode)))
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), getTermios, uintptr(unsafe.Pointer(&mode)))
 
 	if errno != 0 {
 		return nil, errno
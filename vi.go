@@ -0,0 +1,491 @@
+// +build windows linux darwin openbsd freebsd netbsd
+
+package liner
+
+import (
+	"io"
+	"strings"
+	"unicode"
+)
+
+// ViMode is a mode of the vi editing mode.
+type ViMode int
+
+// Modes of the vi editing mode
+const (
+	// ViInsert inserts the characters typed, like the default bindings.
+	ViInsert ViMode = iota
+	// ViCommand moves the cursor and edits the line with vi commands.
+	ViCommand
+)
+
+// SetViMode enables or disables the vi editing mode. In vi mode, Prompt
+// starts in insert mode, using the bindings of ViKeymap unless SetKeymap was
+// given others, and Esc switches to command mode.
+func (s *State) SetViMode(vi bool) {
+	s.viMode = vi
+}
+
+// SetViModeHook sets a function that is called with the prompt passed to
+// Prompt whenever vi mode starts a prompt or switches modes. The prompt it
+// returns is displayed instead, so that it can show the mode.
+func (s *State) SetViModeHook(f func(mode ViMode, prompt string) string) {
+	s.viModeHook = f
+}
+
+var defaultViKeymap = ViKeymap()
+
+// ViKeymap returns a new Keymap with the bindings of the vi insert mode:
+// those of DefaultKeymap except the Alt ones, with Esc bound to
+// vi-movement-mode.
+func ViKeymap() *Keymap {
+	km := DefaultKeymap()
+	// Esc must not wait for another key
+	delete(km.root.children, rune(esc))
+	if err := km.Bind("Esc", "vi-movement-mode"); err != nil {
+		panic(err)
+	}
+	// Esc typed quickly followed by y arrives as Alt-y
+	km.bind("Alt-y", func(e *Editor) error {
+		e.unreadKey('y')
+		return e.viMovementMode()
+	})
+	return km
+}
+
+func (e *Editor) setViMode(mode ViMode) {
+	e.viMode = mode
+	if e.s.viModeHook != nil {
+		e.p = e.s.viModeHook(mode, e.prompt)
+	}
+}
+
+// viClamp keeps the cursor on a character, as it is in command mode.
+func (e *Editor) viClamp() {
+	if e.pos >= len(e.line) && len(e.line) > 0 {
+		e.pos = prevGrapheme(e.line, len(e.line))
+	}
+}
+
+// viMovementMode switches to command mode, and handles vi commands until
+// one of them switches back to insert mode or finishes the prompt.
+func (e *Editor) viMovementMode() error {
+	e.undoAction = true
+	if e.viInsert {
+		e.viInsert = false
+		// Don't keep an undo step for an insert that didn't change the line
+		if n := len(e.undoStack); n > 0 && string(e.undoStack[n-1].line) == string(e.line) {
+			e.undoStack = e.undoStack[:n-1]
+		}
+	}
+	e.setViMode(ViCommand)
+	if e.pos > 0 {
+		e.pos = prevGrapheme(e.line, e.pos)
+	}
+	e.refresh()
+
+	for {
+		e.historyAction = false
+		e.undoAction = false
+		before := e.snapshot()
+		insert, err := e.viCommand()
+		if err != nil || e.accepted {
+			e.trackUndo(before)
+			e.undoAction = true
+			return err
+		}
+		if string(before.line) != string(e.line) {
+			// The message is about the line as it was
+			e.s.message = ""
+		}
+		if insert {
+			// The insert is undone together with the command that
+			// started it
+			e.undoStack = append(e.undoStack, before)
+			e.redoStack = nil
+			e.viInsert = true
+			e.undoAction = true
+			e.setViMode(ViInsert)
+			e.refresh()
+			return nil
+		}
+		e.trackUndo(before)
+		if !e.historyAction {
+			e.resetHistory()
+		}
+		e.viClamp()
+		e.refresh()
+	}
+}
+
+// viCount reads a count, if one is typed, and returns it with the key that
+// follows it. A missing count is 1.
+func (e *Editor) viCount() (int, interface{}, error) {
+	count := 0
+	for {
+		next, err := e.readKey()
+		if err != nil {
+			return 0, nil, err
+		}
+		r, ok := next.(rune)
+		if !ok || r < '0' || r > '9' || (r == '0' && count == 0) {
+			if count == 0 {
+				count = 1
+			}
+			return count, next, nil
+		}
+		count = count*10 + int(r-'0')
+	}
+}
+
+// viCommand reads and runs one vi command. It reports whether the command
+// switched to insert mode.
+func (e *Editor) viCommand() (bool, error) {
+	count, next, err := e.viCount()
+	if err != nil {
+		return false, err
+	}
+	if a, ok := next.(action); ok {
+		switch a {
+		case left:
+			next = 'h'
+		case right:
+			next = 'l'
+		case home:
+			next = '0'
+		case end:
+			next = '$'
+		case up:
+			next = 'k'
+		case down:
+			next = 'j'
+		case winch:
+			return false, nil
+		default:
+			e.Beep()
+			return false, nil
+		}
+	}
+	if _, ok := next.(pastedText); ok {
+		e.Beep()
+		return false, nil
+	}
+
+	switch r := next.(rune); r {
+	case cr, lf:
+		return false, e.acceptLine()
+	case ctrlC:
+		if e.s.ctrlCMode == CtrlCClear {
+			e.setViMode(ViInsert)
+			return true, e.interrupt()
+		}
+		return false, e.interrupt()
+	case ctrlD:
+		if len(e.line) == 0 {
+			return false, io.EOF
+		}
+		e.Beep()
+	case ctrlL:
+		e.s.eraseScreen()
+		e.s.cursorRow = 0
+	case 'i':
+		return true, nil
+	case 'a':
+		if e.pos < len(e.line) {
+			e.pos = nextGrapheme(e.line, e.pos)
+		}
+		return true, nil
+	case 'I':
+		e.pos = 0
+		return true, nil
+	case 'A':
+		e.pos = len(e.line)
+		return true, nil
+	case 'x':
+		if e.pos >= len(e.line) {
+			e.Beep()
+			break
+		}
+		end := e.pos
+		for i := 0; i < count && end < len(e.line); i++ {
+			end = nextGrapheme(e.line, end)
+		}
+		e.viDelete(e.pos, end)
+	case 'r':
+		return false, e.viReplace(count)
+	case 'p', 'P':
+		if e.s.killRing == nil {
+			e.Beep()
+			break
+		}
+		at := e.pos
+		if r == 'p' && at < len(e.line) {
+			at = nextGrapheme(e.line, at)
+		}
+		text := []rune(strings.Repeat(string(e.s.killRing.Value.([]rune)), count))
+		e.line = append(e.line[:at], append(text, e.line[at:]...)...)
+		e.pos = at
+		if len(text) > 0 {
+			e.pos += len(text) - 1
+		}
+	case 'u':
+		for i := 0; i < count; i++ {
+			e.undo()
+		}
+	case ctrlR:
+		for i := 0; i < count; i++ {
+			e.redo()
+		}
+	case 'k':
+		for i := 0; i < count; i++ {
+			e.previousHistory()
+		}
+	case 'j':
+		for i := 0; i < count; i++ {
+			e.nextHistory()
+		}
+	case '/', '?':
+		pattern, ok, err := e.viReadPattern(string(r))
+		if err != nil || !ok {
+			return false, err
+		}
+		if pattern != "" {
+			e.searchPattern = pattern
+		}
+		e.searchBackward = r == '/'
+		e.viSearch(e.searchBackward)
+	case 'n':
+		e.viSearch(e.searchBackward)
+	case 'N':
+		e.viSearch(!e.searchBackward)
+	case 'd', 'c', 'y':
+		return e.viOperator(r, count)
+	default:
+		target, _, ok := viMotion(e.line, e.pos, r, count)
+		if !ok {
+			e.Beep()
+			break
+		}
+		e.pos = target
+	}
+	return false, nil
+}
+
+// viOperator reads the motion that follows the operator op, and applies op
+// to the text it moves over. It reports whether op switched to insert mode.
+func (e *Editor) viOperator(op rune, count int) (bool, error) {
+	n, next, err := e.viCount()
+	if err != nil {
+		return false, err
+	}
+	m, ok := next.(rune)
+	if !ok {
+		e.Beep()
+		return false, nil
+	}
+	count *= n
+
+	start, end := 0, len(e.line)
+	if m != op {
+		pos := e.pos
+		if op == 'c' && m == 'w' && pos < len(e.line) && !unicode.IsSpace(e.line[pos]) {
+			// Like vi, cw changes to the end of the word, which may be
+			// the character under the cursor
+			m = 'e'
+			if pos+1 == len(e.line) || viClass(e.line[pos+1]) != viClass(e.line[pos]) {
+				count--
+			}
+		}
+		target, inclusive, ok := viMotion(e.line, pos, m, count)
+		if !ok {
+			e.Beep()
+			return false, nil
+		}
+		start, end = e.pos, target
+		if end < start {
+			start, end = end, start
+		}
+		if inclusive && end < len(e.line) {
+			end = nextGrapheme(e.line, end)
+		}
+	}
+
+	if start == end && op != 'c' {
+		e.Beep()
+		return false, nil
+	}
+	if op == 'y' {
+		e.s.addToKillRing(e.line[start:end], 0)
+		e.pos = start
+		return false, nil
+	}
+	e.viDelete(start, end)
+	return op == 'c', nil
+}
+
+// viDelete moves line[start:end] to the kill ring, and leaves the cursor at
+// start.
+func (e *Editor) viDelete(start, end int) {
+	if start < end {
+		e.s.addToKillRing(e.line[start:end], 0)
+	}
+	e.line = append(e.line[:start], e.line[end:]...)
+	e.pos = start
+}
+
+// viReplace reads a character, and replaces count characters with it.
+func (e *Editor) viReplace(count int) error {
+	next, err := e.readKey()
+	if err != nil {
+		return err
+	}
+	r, ok := next.(rune)
+	if !ok || r < ' ' || r == bs {
+		if !ok || r != esc {
+			e.Beep()
+		}
+		return nil
+	}
+	end := e.pos
+	for i := 0; i < count; i++ {
+		if end >= len(e.line) {
+			e.Beep()
+			return nil
+		}
+		end = nextGrapheme(e.line, end)
+	}
+	text := []rune(strings.Repeat(string(r), count))
+	e.line = append(e.line[:e.pos], append(text, e.line[end:]...)...)
+	e.pos += count - 1
+	return nil
+}
+
+// viReadPattern reads a search pattern, displaying it after prefix. It
+// reports false if the search is cancelled.
+func (e *Editor) viReadPattern(prefix string) (string, bool, error) {
+	var pattern []rune
+	for {
+		e.s.refresh(prefix, string(pattern), len(pattern))
+		next, err := e.readKey()
+		if err != nil {
+			return "", false, err
+		}
+		r, ok := next.(rune)
+		switch {
+		case !ok:
+			continue
+		case r == cr || r == lf:
+			return string(pattern), true, nil
+		case r == ctrlH || r == bs:
+			if len(pattern) == 0 {
+				return "", false, nil
+			}
+			pattern = pattern[:prevGrapheme(pattern, len(pattern))]
+		case r < ' ':
+			return "", false, nil
+		default:
+			pattern = append(pattern, r)
+		}
+	}
+}
+
+// viSearch replaces the line with the previous (or, if backward is false,
+// the next) history entry that contains the last search pattern.
+func (e *Editor) viSearch(backward bool) {
+	h := historyEntries(e.s.history())
+	if e.searchPos < 0 || e.searchPos > len(h) {
+		e.searchPos = len(h)
+	}
+	if e.searchPattern != "" {
+		if backward {
+			for i := e.searchPos - 1; i >= 0; i-- {
+				if strings.Contains(h[i], e.searchPattern) {
+					e.searchPos = i
+					e.line, e.pos = []rune(h[i]), 0
+					return
+				}
+			}
+		} else {
+			for i := e.searchPos + 1; i < len(h); i++ {
+				if strings.Contains(h[i], e.searchPattern) {
+					e.searchPos = i
+					e.line, e.pos = []rune(h[i]), 0
+					return
+				}
+			}
+		}
+	}
+	e.Beep()
+}
+
+// viClass returns the class of r that vi words are made of: 0 for spaces,
+// 1 for letters, digits and underscores, and 2 for other characters.
+func viClass(r rune) int {
+	switch {
+	case unicode.IsSpace(r):
+		return 0
+	case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r):
+		return 1
+	}
+	return 2
+}
+
+// viMotion returns where the motion m, repeated count times, moves the
+// cursor from pos, and whether an operator applied to it includes the
+// character at the target. It reports false if m isn't a motion.
+func viMotion(line []rune, pos int, m rune, count int) (int, bool, bool) {
+	switch m {
+	case 'h':
+		for i := 0; i < count && pos > 0; i++ {
+			pos = prevGrapheme(line, pos)
+		}
+	case 'l', ' ':
+		for i := 0; i < count && pos < len(line); i++ {
+			pos = nextGrapheme(line, pos)
+		}
+	case 'w':
+		for i := 0; i < count && pos < len(line); i++ {
+			if c := viClass(line[pos]); c != 0 {
+				for pos < len(line) && viClass(line[pos]) == c {
+					pos++
+				}
+			}
+			for pos < len(line) && viClass(line[pos]) == 0 {
+				pos++
+			}
+		}
+	case 'b':
+		for i := 0; i < count && pos > 0; i++ {
+			pos--
+			for pos > 0 && viClass(line[pos]) == 0 {
+				pos--
+			}
+			c := viClass(line[pos])
+			for pos > 0 && viClass(line[pos-1]) == c {
+				pos--
+			}
+		}
+	case 'e':
+		for i := 0; i < count && pos < len(line)-1; i++ {
+			pos++
+			for pos < len(line)-1 && viClass(line[pos]) == 0 {
+				pos++
+			}
+			c := viClass(line[pos])
+			for pos < len(line)-1 && viClass(line[pos+1]) == c {
+				pos++
+			}
+		}
+		return pos, true, true
+	case '0':
+		return 0, false, true
+	case '$':
+		if len(line) == 0 {
+			return 0, true, true
+		}
+		return prevGrapheme(line, len(line)), true, true
+	default:
+		return pos, false, false
+	}
+	return pos, false, true
+}
@@ -0,0 +1,91 @@
+package liner
+
+import "testing"
+
+func TestRuneWidth(t *testing.T) {
+	tests := []struct {
+		r     rune
+		width int
+	}{
+		{'a', 1},
+		{'\u00ad', 1}, // soft hyphen
+		{'é', 1},      // precomposed e with acute
+		{'\u0301', 0}, // combining acute
+		{'\u200b', 0}, // zero width space
+		{'\u200d', 0}, // zero width joiner
+		{'\u1161', 0}, // Hangul jungseong
+		{'あ', 2},      // Hiragana a
+		{'日', 2},      // CJK ideograph
+		{'Ａ', 2},      // fullwidth A
+		{'ｱ', 1},      // halfwidth Katakana a
+		{'\U0001f600', 2},
+		{'\U00020000', 2},
+	}
+	for _, test := range tests {
+		if w := runeWidth(test.r); w != test.width {
+			t.Errorf("Expected width %d for %U, got %d", test.width, test.r, w)
+		}
+	}
+
+	if w := stringWidth("é日本"); w != 5 {
+		t.Errorf("Expected width 5, got %d", w)
+	}
+}
+
+func TestScrollWindow(t *testing.T) {
+	line := []rune("あいうえおかきく")
+	for pos := 0; pos <= len(line); pos++ {
+		start, end := scrollWindow(line, pos, 7)
+		if start > pos || end < pos {
+			t.Fatalf("Cursor %d outside window [%d, %d)", pos, start, end)
+		}
+		width := runesWidth(line[start:end])
+		if start > 0 {
+			width++
+		}
+		if end < len(line) {
+			width++
+		}
+		if width > 7 {
+			t.Fatalf("Window [%d, %d) for cursor %d is %d cells wide", start, end, pos, width)
+		}
+	}
+}
+
+func TestSliceStyled(t *testing.T) {
+	styled := "\x1b[1mselect\x1b[0m \x1b[33m42\x1b[0m"
+	tests := []struct {
+		start, end int
+		expected   string
+	}{
+		{0, 9, "\x1b[1mselect\x1b[0m \x1b[33m42"},
+		{2, 5, "\x1b[1mlec"},
+		{4, 8, "\x1b[1mct\x1b[0m \x1b[33m4"},
+		{7, 9, "\x1b[1m\x1b[0m\x1b[33m42"},
+	}
+	for _, test := range tests {
+		if out := sliceStyled(styled, test.start, test.end); out != test.expected {
+			t.Errorf("Expected %q for %d to %d, got %q", test.expected, test.start, test.end, out)
+		}
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	tests := []struct {
+		text     string
+		row, col int
+	}{
+		{"abc", 0, 3},
+		{"abcde", 0, 5},
+		{"abcdef", 1, 1},
+		{"ab\nc", 1, 5},
+		{"abcde\nf", 1, 5},
+		{"ab日本", 1, 2},
+	}
+	for _, test := range tests {
+		row, col := advance([]rune(test.text), 5, 4, 0, 0)
+		if row != test.row || col != test.col {
+			t.Errorf("advance(%q): expected %d, %d, got %d, %d", test.text, test.row, test.col, row, col)
+		}
+	}
+}